@@ -10,6 +10,15 @@ func TokenFromContext(ctx context.Context) (token string, err error) {
 	return TokenFromMetadata(md)
 }
 
+// TokenFromContextWithMaxLength is like TokenFromContext, but rejects tokens that are longer than maxLength
+func TokenFromContextWithMaxLength(ctx context.Context, maxLength int) (token string, err error) {
+	md, err := MetadataFromContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	return TokenFromMetadataWithMaxLength(md, maxLength)
+}
+
 func KeyFromContext(ctx context.Context) (key string, err error) {
 	md, err := MetadataFromContext(ctx)
 	if err != nil {
@@ -82,16 +82,22 @@ type cacheKey struct {
 }
 
 func (c *DefaultClient) getContext(token string) context.Context {
+	return c.getContextWithParent(context.Background(), token)
+}
+
+// getContextWithParent returns a context with the metadata of this component that inherits the deadline and
+// cancellation of the parent. Metadata of the parent is replaced.
+func (c *DefaultClient) getContextWithParent(parent context.Context, token string) context.Context {
 	if token == "" {
 		token = c.tokenFunc()
 	}
-	md := metadata.Pairs(
-		"service-name", c.self.ServiceName,
-		"id", c.self.Id,
-		"token", token,
-		"net-address", c.self.NetAddress,
-	)
-	ctx := metadata.NewContext(context.Background(), md)
+	md := api.ComponentMetadata{
+		ServiceName: c.self.ServiceName,
+		ID:          c.self.Id,
+		Token:       token,
+		NetAddress:  c.self.NetAddress,
+	}.MD()
+	ctx := metadata.NewContext(parent, md)
 	return ctx
 }
 
@@ -156,6 +162,26 @@ func (c *DefaultClient) Get(serviceName, id string) (*Announcement, error) {
 	return res.(*Announcement), nil
 }
 
+// GetWithContext is like Get, but requests the announcement from the Discovery server within the deadline of the
+// given context. The result is added to the cache.
+func (c *DefaultClient) GetWithContext(ctx context.Context, serviceName, id string) (*Announcement, error) {
+	res, err := c.client.Get(c.getContextWithParent(ctx, ""), &GetRequest{
+		ServiceName: serviceName,
+		Id:          id,
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.cache.Set(cacheKey{serviceName, id}, res)
+	return res, nil
+}
+
+// Invalidate removes the cached service announcement for the given service type and id, so that it is requested
+// from the Discovery server on the next Get
+func (c *DefaultClient) Invalidate(serviceName, id string) {
+	c.cache.Remove(cacheKey{serviceName, id})
+}
+
 // AddMetadata publishes metadata for the current component to the Discovery server
 func (c *DefaultClient) AddMetadata(key Metadata_Key, value []byte, token string) error {
 	_, err := c.client.AddMetadata(c.getContext(token), &MetadataRequest{
@@ -1,6 +1,9 @@
 package api
 
 import (
+	"net/http"
+	"strings"
+
 	"github.com/TheThingsNetwork/ttn/utils/errors"
 	"golang.org/x/net/context" // See https://github.com/grpc/grpc-go/issues/711"
 	"google.golang.org/grpc/metadata"
@@ -8,12 +11,93 @@ import (
 
 // Errors that are returned when an item could not be retrieved
 var (
-	ErrContext = errors.NewErrInternal("Could not get metadata from context")
-	ErrNoToken = errors.NewErrInvalidArgument("Metadata", "token missing")
-	ErrNoKey   = errors.NewErrInvalidArgument("Metadata", "key missing")
-	ErrNoID    = errors.NewErrInvalidArgument("Metadata", "id missing")
+	ErrContext      = errors.NewErrInternal("Could not get metadata from context")
+	ErrNoToken      = errors.NewErrInvalidArgument("Metadata", "token missing")
+	ErrNoKey        = errors.NewErrInvalidArgument("Metadata", "key missing")
+	ErrNoID         = errors.NewErrInvalidArgument("Metadata", "id missing")
+	ErrTokenTooLong = errors.NewErrPermissionDenied("Token too long")
+)
+
+// MaxTokenLength is the maximum length of tokens that are returned by TokenFromMetadata and TokenFromContext. Longer
+// tokens are rejected with ErrTokenTooLong, so that they are never parsed.
+var MaxTokenLength = 8192
+
+// Keys of the metadata that is sent with requests between components
+const (
+	MetadataServiceName = "service-name"
+	MetadataID          = "id"
+	MetadataToken       = "token"
+	MetadataNetAddress  = "net-address"
+	MetadataKey         = "key"
+)
+
+// Headers of HTTP requests between components that carry the same values as the metadata of RPC requests. The token is
+// sent as bearer token in the Authorization header.
+const (
+	HeaderServiceName = "X-TTN-Service-Name"
+	HeaderID          = "X-TTN-ID"
+	HeaderNetAddress  = "X-TTN-Net-Address"
 )
 
+// ComponentMetadata is the metadata that identifies a component in requests to other components
+type ComponentMetadata struct {
+	ServiceName string
+	ID          string
+	Token       string
+	NetAddress  string
+}
+
+// MD builds the metadata pairs for the ComponentMetadata
+func (m ComponentMetadata) MD() metadata.MD {
+	return metadata.Pairs(
+		MetadataServiceName, m.ServiceName,
+		MetadataID, m.ID,
+		MetadataToken, m.Token,
+		MetadataNetAddress, m.NetAddress,
+	)
+}
+
+// ComponentMetadataFromMD parses the ComponentMetadata from the metadata. Fields that are missing or that have more
+// than one value are left empty.
+func ComponentMetadataFromMD(md metadata.MD) ComponentMetadata {
+	get := func(key string) string {
+		if values, ok := md[key]; ok && len(values) == 1 {
+			return values[0]
+		}
+		return ""
+	}
+	return ComponentMetadata{
+		ServiceName: get(MetadataServiceName),
+		ID:          get(MetadataID),
+		Token:       get(MetadataToken),
+		NetAddress:  get(MetadataNetAddress),
+	}
+}
+
+// SetHeader sets the ComponentMetadata on the headers of an HTTP request
+func (m ComponentMetadata) SetHeader(header http.Header) {
+	header.Set(HeaderServiceName, m.ServiceName)
+	header.Set(HeaderID, m.ID)
+	header.Set(HeaderNetAddress, m.NetAddress)
+	if m.Token != "" {
+		header.Set("Authorization", "Bearer "+m.Token)
+	}
+}
+
+// ComponentMetadataFromHeader parses the ComponentMetadata from the headers of an HTTP request. Use MD on the result
+// to validate the request like an RPC request.
+func ComponentMetadataFromHeader(header http.Header) ComponentMetadata {
+	m := ComponentMetadata{
+		ServiceName: header.Get(HeaderServiceName),
+		ID:          header.Get(HeaderID),
+		NetAddress:  header.Get(HeaderNetAddress),
+	}
+	if auth := header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		m.Token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return m
+}
+
 func MetadataFromContext(ctx context.Context) (metadata.MD, error) {
 	md, ok := metadata.FromContext(ctx)
 	if !ok {
@@ -23,7 +107,7 @@ func MetadataFromContext(ctx context.Context) (metadata.MD, error) {
 }
 
 func IDFromMetadata(md metadata.MD) (string, error) {
-	id, ok := md["id"]
+	id, ok := md[MetadataID]
 	if !ok || len(id) == 0 {
 		return "", ErrNoID
 	}
@@ -31,15 +115,23 @@ func IDFromMetadata(md metadata.MD) (string, error) {
 }
 
 func TokenFromMetadata(md metadata.MD) (string, error) {
-	token, ok := md["token"]
+	return TokenFromMetadataWithMaxLength(md, MaxTokenLength)
+}
+
+// TokenFromMetadataWithMaxLength is like TokenFromMetadata, but rejects tokens that are longer than maxLength
+func TokenFromMetadataWithMaxLength(md metadata.MD, maxLength int) (string, error) {
+	token, ok := md[MetadataToken]
 	if !ok || len(token) == 0 {
 		return "", ErrNoToken
 	}
+	if len(token[0]) > maxLength {
+		return "", ErrTokenTooLong
+	}
 	return token[0], nil
 }
 
 func KeyFromMetadata(md metadata.MD) (string, error) {
-	key, ok := md["key"]
+	key, ok := md[MetadataKey]
 	if !ok || len(key) == 0 {
 		return "", ErrNoKey
 	}
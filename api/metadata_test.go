@@ -0,0 +1,80 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/assertions"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestComponentMetadata(t *testing.T) {
+	a := New(t)
+
+	in := ComponentMetadata{
+		ServiceName: "router",
+		ID:          "dev",
+		Token:       "token",
+		NetAddress:  "localhost:1901",
+	}
+
+	ctx := metadata.NewContext(context.Background(), in.MD())
+
+	md, err := MetadataFromContext(ctx)
+	a.So(err, ShouldBeNil)
+	a.So(ComponentMetadataFromMD(md), ShouldResemble, in)
+
+	id, err := IDFromMetadata(md)
+	a.So(err, ShouldBeNil)
+	a.So(id, ShouldEqual, "dev")
+
+	token, err := TokenFromContext(ctx)
+	a.So(err, ShouldBeNil)
+	a.So(token, ShouldEqual, "token")
+
+	// Duplicate values are ignored
+	md = metadata.Join(md, metadata.Pairs(MetadataToken, "other-token"))
+	a.So(ComponentMetadataFromMD(md).Token, ShouldBeEmpty)
+	a.So(ComponentMetadataFromMD(md).ID, ShouldEqual, "dev")
+
+	a.So(ComponentMetadataFromMD(metadata.MD{}), ShouldResemble, ComponentMetadata{})
+}
+
+func TestComponentMetadataHeader(t *testing.T) {
+	a := New(t)
+
+	in := ComponentMetadata{
+		ServiceName: "router",
+		ID:          "dev",
+		Token:       "token",
+		NetAddress:  "localhost:1901",
+	}
+
+	header := make(http.Header)
+	in.SetHeader(header)
+	a.So(header.Get("Authorization"), ShouldEqual, "Bearer token")
+	a.So(header.Get(HeaderID), ShouldEqual, "dev")
+	a.So(ComponentMetadataFromHeader(header), ShouldResemble, in)
+
+	// Other authorization schemes are ignored
+	header.Set("Authorization", "Basic dXNlcjpwYXNz")
+	a.So(ComponentMetadataFromHeader(header).Token, ShouldBeEmpty)
+}
+
+func TestTokenMaxLength(t *testing.T) {
+	a := New(t)
+
+	long := strings.Repeat("x", MaxTokenLength+1)
+	ctx := metadata.NewContext(context.Background(), metadata.Pairs(MetadataToken, long))
+	_, err := TokenFromContext(ctx)
+	a.So(err, ShouldEqual, ErrTokenTooLong)
+
+	token, err := TokenFromContextWithMaxLength(ctx, len(long))
+	a.So(err, ShouldBeNil)
+	a.So(token, ShouldEqual, long)
+
+	_, err = TokenFromContextWithMaxLength(ctx, 10)
+	a.So(err, ShouldEqual, ErrTokenTooLong)
+}
@@ -63,7 +63,7 @@ var brokerCmd = &cobra.Command{
 		}
 
 		// gRPC Server
-		lis, err := net.Listen("tcp", fmt.Sprintf("%s:%d", viper.GetString("broker.server-address"), viper.GetInt("broker.server-port")))
+		lis, err := net.Listen("tcp", component.Config.ListenAddress)
 		if err != nil {
 			ctx.WithError(err).Fatal("Could not start gRPC server")
 		}
@@ -71,6 +71,7 @@ var brokerCmd = &cobra.Command{
 
 		// Register and Listen
 		broker.RegisterRPC(grpc)
+		component.RegisterMetricsRPC(grpc)
 		broker.RegisterManager(grpc)
 		go grpc.Serve(lis)
 
@@ -78,6 +79,8 @@ var brokerCmd = &cobra.Command{
 		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 		ctx.WithField("signal", <-sigChan).Info("signal received")
 
+		drainAuth(component)
+
 		grpc.Stop()
 		broker.Shutdown()
 	},
@@ -44,7 +44,8 @@ var discoveryCmd = &cobra.Command{
 		connectRedis(client)
 
 		// Component
-		component, err := component.New(ctx, "discovery", fmt.Sprintf("%s:%d", viper.GetString("discovery.server-address-announce"), viper.GetInt("discovery.server-port")))
+		// The Discovery server does not announce itself, so it uses its listen address
+		component, err := component.New(ctx, "discovery", "")
 		if err != nil {
 			ctx.WithError(err).Fatal("Could not initialize component")
 		}
@@ -60,7 +61,7 @@ var discoveryCmd = &cobra.Command{
 		}
 
 		// gRPC Server
-		lis, err := net.Listen("tcp", fmt.Sprintf("%s:%d", viper.GetString("discovery.server-address"), viper.GetInt("discovery.server-port")))
+		lis, err := net.Listen("tcp", component.Config.ListenAddress)
 		if err != nil {
 			ctx.WithError(err).Fatal("Could not start gRPC server")
 		}
@@ -68,12 +69,15 @@ var discoveryCmd = &cobra.Command{
 
 		// Register and Listen
 		discovery.RegisterRPC(grpc)
+		component.RegisterMetricsRPC(grpc)
 		go grpc.Serve(lis)
 
 		sigChan := make(chan os.Signal)
 		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 		ctx.WithField("signal", <-sigChan).Info("signal received")
 
+		drainAuth(component)
+
 		grpc.Stop()
 	},
 }
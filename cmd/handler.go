@@ -88,7 +88,7 @@ var handlerCmd = &cobra.Command{
 		defer handler.Shutdown()
 
 		// gRPC Server
-		lis, err := net.Listen("tcp", fmt.Sprintf("%s:%d", viper.GetString("handler.server-address"), viper.GetInt("handler.server-port")))
+		lis, err := net.Listen("tcp", component.Config.ListenAddress)
 		if err != nil {
 			ctx.WithError(err).Fatal("Could not start gRPC server")
 		}
@@ -96,6 +96,7 @@ var handlerCmd = &cobra.Command{
 
 		// Register and Listen
 		handler.RegisterRPC(grpc)
+		component.RegisterMetricsRPC(grpc)
 		handler.RegisterManager(grpc)
 		go grpc.Serve(lis)
 		defer grpc.Stop()
@@ -128,6 +129,8 @@ var handlerCmd = &cobra.Command{
 		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 		ctx.WithField("signal", <-sigChan).Info("signal received")
 
+		drainAuth(component)
+
 	},
 }
 
@@ -75,7 +75,7 @@ var networkserverCmd = &cobra.Command{
 		}
 
 		// gRPC Server
-		lis, err := net.Listen("tcp", fmt.Sprintf("%s:%d", viper.GetString("networkserver.server-address"), viper.GetInt("networkserver.server-port")))
+		lis, err := net.Listen("tcp", component.Config.ListenAddress)
 		if err != nil {
 			ctx.WithError(err).Fatal("Could not start gRPC server")
 		}
@@ -83,6 +83,7 @@ var networkserverCmd = &cobra.Command{
 
 		// Register and Listen
 		networkserver.RegisterRPC(grpc)
+		component.RegisterMetricsRPC(grpc)
 		networkserver.RegisterManager(grpc)
 		go grpc.Serve(lis)
 
@@ -90,6 +91,8 @@ var networkserverCmd = &cobra.Command{
 		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 		ctx.WithField("signal", <-sigChan).Info("signal received")
 
+		drainAuth(component)
+
 		grpc.Stop()
 		networkserver.Shutdown()
 	},
@@ -23,7 +23,7 @@ var networkserverAuthorizeCmd = &cobra.Command{
 			cmd.UsageFunc()(cmd)
 		}
 
-		privKey, err := security.LoadKeypair(viper.GetString("key-dir"))
+		privKey, err := security.LoadKeypairWithPassphrase(viper.GetString("key-dir"), []byte(viper.GetString("key-passphrase")))
 		if err != nil {
 			ctx.WithError(err).Fatal("Could not load security keys")
 		}
@@ -14,6 +14,7 @@ import (
 	"time"
 
 	cliHandler "github.com/TheThingsNetwork/go-utils/handlers/cli"
+	"github.com/TheThingsNetwork/ttn/core/component"
 	esHandler "github.com/TheThingsNetwork/ttn/utils/elasticsearch/handler"
 	"github.com/apex/log"
 	jsonHandler "github.com/apex/log/handlers/json"
@@ -23,6 +24,7 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/tj/go-elastic"
+	"golang.org/x/net/context" // See https://github.com/grpc/grpc-go/issues/711"
 	"gopkg.in/redis.v5"
 )
 
@@ -78,11 +80,16 @@ var RootCmd = &cobra.Command{
 		ctx = &log.Logger{
 			Handler: multiHandler.New(logHandlers...),
 		}
+		authServers := make(map[string]string)
+		for id, authServer := range viper.GetStringMapString("auth-servers") {
+			authServers[id] = component.RedactAuthServer(authServer)
+		}
+
 		ctx.WithFields(log.Fields{
 			"ComponentID":              viper.GetString("id"),
 			"Description":              viper.GetString("description"),
 			"Discovery Server Address": viper.GetString("discovery-address"),
-			"Auth Servers":             viper.GetStringMapString("auth-servers"),
+			"Auth Servers":             authServers,
 			"Monitors":                 viper.GetStringMapString("monitor-servers"),
 		}).Info("Initializing The Things Network")
 	},
@@ -127,16 +134,28 @@ func init() {
 	RootCmd.PersistentFlags().String("discovery-address", "discover.thethingsnetwork.org:1900", "The address of the Discovery server")
 	viper.BindPFlag("discovery-address", RootCmd.PersistentFlags().Lookup("discovery-address"))
 
+	RootCmd.PersistentFlags().Duration("announce-timeout", 2*time.Minute, "The time after which announcing to the Discovery server is given up")
+	viper.BindPFlag("announce-timeout", RootCmd.PersistentFlags().Lookup("announce-timeout"))
+
+	RootCmd.PersistentFlags().Duration("announce-interval", 10*time.Minute, "The interval at which the component is announced to the Discovery server again")
+	viper.BindPFlag("announce-interval", RootCmd.PersistentFlags().Lookup("announce-interval"))
+
 	viper.SetDefault("auth-servers", map[string]string{
 		"ttn-account": "https://account.thethingsnetwork.org",
 	})
 
+	RootCmd.PersistentFlags().Duration("auth-token-key-refresh-interval", time.Hour, "The interval at which the public keys of the auth servers are refreshed")
+	viper.BindPFlag("auth-token-key-refresh-interval", RootCmd.PersistentFlags().Lookup("auth-token-key-refresh-interval"))
+
 	RootCmd.PersistentFlags().String("auth-token", "", "The JWT token to be used for the discovery server")
 	viper.BindPFlag("auth-token", RootCmd.PersistentFlags().Lookup("auth-token"))
 
 	RootCmd.PersistentFlags().Int("health-port", 0, "The port number where the health server should be started")
 	viper.BindPFlag("health-port", RootCmd.PersistentFlags().Lookup("health-port"))
 
+	RootCmd.PersistentFlags().Bool("metrics-rpc", false, "Expose the metrics to authenticated components over gRPC")
+	viper.BindPFlag("metrics-rpc", RootCmd.PersistentFlags().Lookup("metrics-rpc"))
+
 	dir, err := homedir.Dir()
 	if err == nil {
 		dir, _ = homedir.Expand(dir)
@@ -151,9 +170,24 @@ func init() {
 	RootCmd.PersistentFlags().Bool("tls", false, "Use TLS")
 	viper.BindPFlag("tls", RootCmd.PersistentFlags().Lookup("tls"))
 
+	RootCmd.PersistentFlags().String("ca-path", "", "The location of a PEM-encoded CA bundle to verify TLS peers against (default: system CAs)")
+	viper.BindPFlag("ca-path", RootCmd.PersistentFlags().Lookup("ca-path"))
+
 	RootCmd.PersistentFlags().String("key-dir", path.Clean(dir+"/.ttn/"), "The directory where public/private keys are stored")
 	viper.BindPFlag("key-dir", RootCmd.PersistentFlags().Lookup("key-dir"))
 
+	RootCmd.PersistentFlags().String("key-passphrase", "", "The passphrase to decrypt the private key with if it is encrypted")
+	viper.BindPFlag("key-passphrase", RootCmd.PersistentFlags().Lookup("key-passphrase"))
+
+	RootCmd.PersistentFlags().String("key-permissions", "warn", "What to do if the private key is accessible by others (warn, enforce or ignore)")
+	viper.BindPFlag("key-permissions", RootCmd.PersistentFlags().Lookup("key-permissions"))
+
+	RootCmd.PersistentFlags().Duration("token-ttl", 20*time.Second, "The lifetime of the tokens this component issues to authenticate itself")
+	viper.BindPFlag("token-ttl", RootCmd.PersistentFlags().Lookup("token-ttl"))
+
+	RootCmd.PersistentFlags().Duration("clock-skew", 0, "The tolerated clock skew when validating tokens of other components (30s recommended)")
+	viper.BindPFlag("clock-skew", RootCmd.PersistentFlags().Lookup("clock-skew"))
+
 	RootCmd.PersistentFlags().Bool("no-cli-logs", false, "Disable CLI logs")
 	viper.BindPFlag("no-cli-logs", RootCmd.PersistentFlags().Lookup("no-cli-logs"))
 
@@ -210,3 +244,15 @@ func connectRedis(client *redis.Client) error {
 	}
 	return nil
 }
+
+// DrainAuthTimeout is the time that components wait for in-flight auth validations when they shut down
+var DrainAuthTimeout = 10 * time.Second
+
+// drainAuth waits for the in-flight auth validations of the component before it shuts down
+func drainAuth(c *component.Component) {
+	drainCtx, cancel := context.WithTimeout(context.Background(), DrainAuthTimeout)
+	defer cancel()
+	if err := c.DrainAuth(drainCtx); err != nil {
+		ctx.WithError(err).Warn("Could not drain auth validations")
+	}
+}
@@ -46,7 +46,7 @@ var routerCmd = &cobra.Command{
 		}
 
 		// gRPC Server
-		lis, err := net.Listen("tcp", fmt.Sprintf("%s:%d", viper.GetString("router.server-address"), viper.GetInt("router.server-port")))
+		lis, err := net.Listen("tcp", component.Config.ListenAddress)
 		if err != nil {
 			ctx.WithError(err).Fatal("Could not start gRPC server")
 		}
@@ -54,6 +54,7 @@ var routerCmd = &cobra.Command{
 
 		// Register and Listen
 		router.RegisterRPC(grpc)
+		component.RegisterMetricsRPC(grpc)
 		router.RegisterManager(grpc)
 		go grpc.Serve(lis)
 
@@ -61,6 +62,8 @@ var routerCmd = &cobra.Command{
 		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 		ctx.WithField("signal", <-sigChan).Info("signal received")
 
+		drainAuth(component)
+
 		grpc.Stop()
 		router.Shutdown()
 	},
@@ -131,7 +131,7 @@ func (b *broker) HandleActivation(activation *pb.DeviceActivationRequest) (*pb.D
 	var wg sync.WaitGroup
 	responses := make(chan *challengeResponseWithHandler, len(announcements))
 	for _, announcement := range announcements {
-		conn, err := announcement.Dial()
+		conn, err := b.Dial(announcement)
 		if err != nil {
 			ctx.WithError(err).Warn("Could not dial handler for Activation")
 			continue
@@ -9,7 +9,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/TheThingsNetwork/ttn/api"
 	pb "github.com/TheThingsNetwork/ttn/api/broker"
 	pb_discovery "github.com/TheThingsNetwork/ttn/api/discovery"
 	"github.com/TheThingsNetwork/ttn/api/networkserver"
@@ -120,12 +119,13 @@ func (b *broker) Init(c *component.Component) error {
 	if err != nil {
 		return err
 	}
-	err = b.Component.Announce()
+	err = b.Component.AnnounceWithRetry()
 	if err != nil {
 		return err
 	}
+	b.Component.StartAnnounceRefresh(c.Config.GetAnnounceInterval())
 	b.Discovery.GetAll("handler") // Update cache
-	conn, err := api.DialWithCert(b.nsAddr, b.nsCert)
+	conn, err := b.Dial(&pb_discovery.Announcement{NetAddress: b.nsAddr, Certificate: b.nsCert})
 	if err != nil {
 		return err
 	}
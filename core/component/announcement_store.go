@@ -0,0 +1,52 @@
+package component
+
+import (
+	"time"
+
+	pb_discovery "github.com/TheThingsNetwork/ttn/api/discovery"
+	"github.com/bluele/gcache"
+)
+
+// AnnouncementStore stores the announcements of other components that were discovered. A single AnnouncementStore
+// can be shared by multiple components that run in the same process, so that they do not discover the same
+// components separately.
+type AnnouncementStore interface {
+	// Get returns the stored announcement for the given service name and id, or false if there is none
+	Get(serviceName, id string) (*pb_discovery.Announcement, bool)
+	// Put stores the announcement for the given service name and id
+	Put(serviceName, id string, announcement *pb_discovery.Announcement)
+	// Remove removes the stored announcement for the given service name and id
+	Remove(serviceName, id string)
+}
+
+// NewAnnouncementStore returns a new in-memory AnnouncementStore that holds up to size announcements for the given TTL
+func NewAnnouncementStore(size int, ttl time.Duration) AnnouncementStore {
+	return &announcementStore{
+		cache: gcache.New(size).Expiration(ttl).ARC().Build(),
+	}
+}
+
+type announcementCacheKey struct {
+	serviceName string
+	id          string
+}
+
+type announcementStore struct {
+	cache gcache.Cache
+}
+
+func (s *announcementStore) Get(serviceName, id string) (*pb_discovery.Announcement, bool) {
+	res, err := s.cache.Get(announcementCacheKey{serviceName, id})
+	if err != nil {
+		return nil, false
+	}
+	return res.(*pb_discovery.Announcement), true
+}
+
+func (s *announcementStore) Put(serviceName, id string, announcement *pb_discovery.Announcement) {
+	s.cache.Set(announcementCacheKey{serviceName, id}, announcement)
+}
+
+func (s *announcementStore) Remove(serviceName, id string) {
+	s.cache.Remove(announcementCacheKey{serviceName, id})
+}
@@ -1,34 +1,51 @@
 package component
 
 import (
+	"crypto/ecdsa"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/TheThingsNetwork/go-account-lib/cache"
 	"github.com/TheThingsNetwork/go-account-lib/claims"
 	"github.com/TheThingsNetwork/go-account-lib/keys"
-	"github.com/TheThingsNetwork/go-account-lib/oauth"
+	"github.com/TheThingsNetwork/go-account-lib/rights"
 	"github.com/TheThingsNetwork/go-account-lib/tokenkey"
 	"github.com/TheThingsNetwork/ttn/api"
 	pb_discovery "github.com/TheThingsNetwork/ttn/api/discovery"
 	"github.com/TheThingsNetwork/ttn/utils/errors"
 	"github.com/TheThingsNetwork/ttn/utils/security"
+	"github.com/apex/log"
 	jwt "github.com/dgrijalva/jwt-go"
 	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 )
 
 // InitAuth initializes Auth functionality
 func (c *Component) InitAuth() error {
 	inits := []func() error{
+		c.initMetrics,
+		c.initTokenTTL,
 		c.initAuthServers,
 		c.initKeyPair,
+		c.initClientTLS,
 	}
 	if c.Config.UseTLS {
 		inits = append(inits, c.initTLS)
 	}
+	inits = append(inits, c.initTokenKeyRefresh)
 
 	for _, init := range inits {
 		if err := init(); err != nil {
@@ -39,6 +56,16 @@ func (c *Component) InitAuth() error {
 	return nil
 }
 
+func (c *Component) initTokenTTL() error {
+	if c.Config.TokenTTL == 0 {
+		return nil
+	}
+	if c.Config.TokenTTL < MinTokenTTL {
+		return errors.NewErrInvalidArgument("Token TTL", fmt.Sprintf("must be at least %s", MinTokenTTL))
+	}
+	return nil
+}
+
 type authServer struct {
 	url      string
 	username string
@@ -48,7 +75,7 @@ type authServer struct {
 func parseAuthServer(str string) (srv authServer, err error) {
 	matches := AuthServerRegex.FindStringSubmatch(str)
 	if len(matches) != 5 || matches[4] == "" {
-		return srv, ErrNoAuthServerRegexMatch
+		return srv, authServerError(str)
 	}
 	return authServer{
 		url:      matches[1] + matches[4],
@@ -57,114 +84,695 @@ func parseAuthServer(str string) (srv authServer, err error) {
 	}, nil
 }
 
+// checkDuplicateAuthServers returns an error if the IDs of two auth servers only differ in case or surrounding
+// whitespace. Such IDs are merged by the config loader and share a key file on case-insensitive file systems, so one
+// of the auth servers would silently disappear.
+func checkDuplicateAuthServers(servers map[string]string) error {
+	ids := make([]string, 0, len(servers))
+	for id := range servers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	seen := make(map[string]string, len(ids))
+	for _, id := range ids {
+		normalized := strings.ToLower(strings.TrimSpace(id))
+		if other, ok := seen[normalized]; ok {
+			return errors.NewErrInvalidArgument("Auth servers", fmt.Sprintf("%s (%s) and %s (%s) have the same ID",
+				other, RedactAuthServer(servers[other]), id, RedactAuthServer(servers[id])))
+		}
+		seen[normalized] = id
+	}
+	return nil
+}
+
+// credentialEnvRegex matches credentials that reference an environment variable
+var credentialEnvRegex = regexp.MustCompile(`^\$\{([A-Za-z_][A-Za-z0-9_]*)\}$`)
+
+// resolveCredential returns the value of the environment variable if the credential has the format ${NAME}, and the
+// credential itself otherwise
+func resolveCredential(credential string) (string, error) {
+	matches := credentialEnvRegex.FindStringSubmatch(credential)
+	if matches == nil {
+		return credential, nil
+	}
+	value, ok := os.LookupEnv(matches[1])
+	if !ok {
+		return "", errors.NewErrInvalidArgument("Auth server credentials", fmt.Sprintf("environment variable %s is not set", matches[1]))
+	}
+	return value, nil
+}
+
+// credentials returns the username and password of the auth server. Credentials that reference an environment
+// variable are resolved on every call, so that they can be rotated without a restart.
+func (srv authServer) credentials() (username, password string, err error) {
+	if username, err = resolveCredential(srv.username); err != nil {
+		return "", "", err
+	}
+	if password, err = resolveCredential(srv.password); err != nil {
+		return "", "", err
+	}
+	return username, password, nil
+}
+
+// authServerError returns an error that indicates which part of the auth server configuration is invalid
+func authServerError(str string) error {
+	redacted := RedactAuthServer(str)
+	scheme := authServerSchemeRegex.FindString(str)
+	if scheme == "" {
+		return errors.NewErrInvalidArgument("Auth server scheme", fmt.Sprintf("%s does not start with http:// or https://", redacted))
+	}
+	rest := strings.TrimPrefix(str, scheme)
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		if !authServerCredentialsRegex.MatchString(rest[:i]) {
+			return errors.NewErrInvalidArgument("Auth server credentials", fmt.Sprintf("%s does not contain a valid username or password", redacted))
+		}
+	}
+	return errors.NewErrInvalidArgument("Auth server domain", fmt.Sprintf("%s does not contain a valid domain, address or port", redacted))
+}
+
+// RedactAuthServer removes the password from the auth server configuration
+func RedactAuthServer(str string) string {
+	rest := str
+	var scheme string
+	if i := strings.Index(rest, "://"); i >= 0 {
+		scheme, rest = rest[:i+3], rest[i+3:]
+	}
+	i := strings.LastIndex(rest, "@")
+	if i < 0 {
+		return str
+	}
+	credentials := rest[:i]
+	if j := strings.Index(credentials, ":"); j >= 0 {
+		credentials = credentials[:j] + ":***"
+	}
+	return scheme + credentials + rest[i:]
+}
+
+const (
+	authServerSchemePattern      = `(http[s]?://)`
+	authServerCredentialsPattern = `(\$\{[A-Za-z_][A-Za-z0-9_]*\}|[0-9a-z_-]+)(?::([0-9A-Za-z-!"#$%&'()*+,.:;<=>?@[\]^_{|}~]+))?`
+	authServerDomainPattern      = `((?:[0-9a-z.-]+|\[[0-9A-Fa-f:.]+\])(?::[0-9]{1,5})?)`
+)
+
 // AuthServerRegex gives the format of auth server configuration.
-// Format: [username[:password]@]domain
+// Format: [username[:password]@]domain[:port]
 // - usernames can contain lowercase letters, numbers, underscores and dashes
 // - passwords can contain uppercase and lowercase letters, numbers, and special characters
+// - usernames and passwords of the format ${NAME} are read from the environment variable NAME when they are used
 // - domains can be http/https and can contain lowercase letters, numbers, dashes and dots
-var AuthServerRegex = regexp.MustCompile(`^(http[s]?://)(?:([0-9a-z_-]+)(?::([0-9A-Za-z-!"#$%&'()*+,.:;<=>?@[\]^_{|}~]+))?@)?([0-9a-z.-]+)/?$`)
+// - instead of a domain, an IPv4 address or an IPv6 address in square brackets can be used
+// - domains and addresses can be followed by a port
+var AuthServerRegex = regexp.MustCompile(`^` + authServerSchemePattern + `(?:` + authServerCredentialsPattern + `@)?` + authServerDomainPattern + `/?$`)
 
-// ErrNoAuthServerRegexMatch is returned when an auth server
-var ErrNoAuthServerRegexMatch = errors.New("Account server did not match AuthServerRegex")
+var (
+	authServerSchemeRegex      = regexp.MustCompile(`^` + authServerSchemePattern)
+	authServerCredentialsRegex = regexp.MustCompile(`^` + authServerCredentialsPattern + `$`)
+)
 
 func (c *Component) initAuthServers() error {
+	if len(c.Config.AuthServers) == 0 {
+		if !c.Config.AllowNoAuthServers {
+			return errors.NewErrInvalidArgument("Auth servers", "at least one auth server must be configured")
+		}
+		if c.Ctx != nil {
+			c.Ctx.Warn("ttn: No auth servers configured, tokens from auth servers can not be validated")
+		}
+	}
+	if err := checkDuplicateAuthServers(c.Config.AuthServers); err != nil {
+		return err
+	}
 	urlMap := make(map[string]string)
 	for id, url := range c.Config.AuthServers {
 		srv, err := parseAuthServer(url)
 		if err != nil {
-			return err
+			return errors.Wrapf(err, "Invalid configuration for auth server %s", id)
 		}
 		urlMap[id] = srv.url
 	}
-	c.TokenKeyProvider = tokenkey.HTTPProvider(
-		urlMap,
-		cache.WriteTroughCacheWithFormat(c.Config.KeyDir, "auth-%s.pub"),
-	)
+	clients := make(map[string]*http.Client)
+	for id, cert := range c.Config.AuthServerClientCerts {
+		if _, ok := urlMap[id]; !ok {
+			return errors.NewErrInvalidArgument("Auth server client certificate", fmt.Sprintf("auth server %s is not configured", id))
+		}
+		client, err := c.buildAuthServerClient(cert)
+		if err != nil {
+			return errors.Wrapf(err, "Could not load client certificate for auth server %s", id)
+		}
+		clients[id] = client
+	}
+	for id := range c.Config.AuthServerKeyPins {
+		if _, ok := urlMap[id]; !ok {
+			return errors.NewErrInvalidArgument("Auth server key pin", fmt.Sprintf("auth server %s is not configured", id))
+		}
+	}
+	keyCache := newTokenKeyCache(cache.WriteTroughCacheWithFormat(c.Config.KeyDir, "auth-%s.pub"), c.Config.AuthServerKeyPins, c.Ctx)
+	var provider tokenkey.Provider = &clientTokenKeyProvider{urls: urlMap, clients: clients, cache: keyCache}
+	if len(c.Config.AuthServerKeyPins) > 0 {
+		provider = &pinnedTokenKeyProvider{Provider: provider, pins: c.Config.AuthServerKeyPins}
+	}
+	c.tokenKeyLock.Lock()
+	c.TokenKeyProvider = provider
+	c.tokenKeyCache = keyCache
+	c.authServerClients = clients
+	c.tokenKeyLock.Unlock()
 	return nil
 }
 
-// UpdateTokenKey updates the OAuth Bearer token key
+// buildAuthServerClient returns an http.Client that presents the given client certificate. Server certificates are
+// verified against the configured CAPath, or the system CAs if no CAPath is configured.
+func (c *Component) buildAuthServerClient(cert ClientCert) (*http.Client, error) {
+	keyPair, err := tls.LoadX509KeyPair(cert.CertFile, cert.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		Certificates:       []tls.Certificate{keyPair},
+		InsecureSkipVerify: c.Config.TLSInsecureSkipVerify,
+	}
+	if c.Config.CAPath != "" {
+		roots, err := security.LoadCertPool(c.Config.CAPath)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = roots
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}, nil
+}
+
+// authServerClient returns the http.Client for the given auth server, or http.DefaultClient if the auth server does
+// not require a client certificate
+func (c *Component) authServerClient(id string) *http.Client {
+	c.tokenKeyLock.RLock()
+	defer c.tokenKeyLock.RUnlock()
+	if client, ok := c.authServerClients[id]; ok {
+		return client
+	}
+	return http.DefaultClient
+}
+
+// UpdateTokenKey updates the OAuth Bearer token key. If the TokenKeyProvider supports it, the keys are fetched before
+// the tokenKeyLock is taken, so that slow auth servers do not block token validation. Other providers are updated
+// while holding the lock.
 func (c *Component) UpdateTokenKey() error {
-	if c.TokenKeyProvider == nil {
+	// Set up Auth Server Token Validation
+	c.tokenKeyLock.RLock()
+	provider := c.TokenKeyProvider
+	c.tokenKeyLock.RUnlock()
+	if provider == nil {
 		return errors.NewErrInternal("No public key provider configured for token validation")
 	}
+	apply := provider.Update
+	if staged, ok := provider.(stagedTokenKeyProvider); ok {
+		apply = staged.fetchKeys()
+	}
+	c.tokenKeyLock.Lock()
+	err := apply()
+	c.tokenKeyLock.Unlock()
 
-	// Set up Auth Server Token Validation
-	err := c.TokenKeyProvider.Update()
+	status := c.TokenKeyStatus()
+	servers := make([]string, 0, len(status))
+	var keys int
+	for id, info := range status {
+		servers = append(servers, id)
+		if info.Cached {
+			keys++
+		}
+	}
+	sort.Strings(servers)
+	ctx := c.Ctx.WithFields(log.Fields{
+		"AuthServers": servers,
+		"Keys":        keys,
+	})
 	if err != nil {
-		c.Ctx.Warnf("ttn: Failed to refresh public keys for token validation: %s", err.Error())
+		ctx.WithError(err).Warn("ttn: Failed to refresh public keys for token validation")
 	} else {
-		c.Ctx.Info("ttn: Got public keys for token validation")
+		ctx.Info("ttn: Got public keys for token validation")
 	}
 
 	return nil
 }
 
+// initTokenKeyRefresh starts refreshing the public keys of the configured auth servers at the configured
+// TokenKeyRefreshInterval. The refresh is only started once, also if InitAuth is called again.
+func (c *Component) initTokenKeyRefresh() error {
+	if len(c.Config.AuthServers) == 0 {
+		return nil
+	}
+	c.tokenKeyRefreshOnce.Do(func() {
+		c.StartTokenKeyRefresh(c.Config.GetTokenKeyRefreshInterval())
+	})
+	return nil
+}
+
+// StartTokenKeyRefresh starts a goroutine that refreshes the OAuth Bearer token keys at the given interval.
+// Failed refreshes are logged, the previously fetched keys remain in use. The returned function stops the goroutine.
+// DrainAuth also stops it.
+func (c *Component) StartTokenKeyRefresh(interval time.Duration) (stop func()) {
+	return c.startRefresh(interval, func() {
+		if err := c.UpdateTokenKey(); err != nil {
+			c.Ctx.WithError(err).Warn("ttn: Could not refresh public keys for token validation")
+		}
+	})
+}
+
+// startRefresh starts a goroutine that calls refresh at the given interval until the returned function or DrainAuth
+// is called
+func (c *Component) startRefresh(interval time.Duration, refresh func()) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				refresh()
+			}
+		}
+	}()
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			close(done)
+		})
+		<-stopped
+	}
+	c.refreshLock.Lock()
+	c.refreshStops = append(c.refreshStops, stop)
+	c.refreshLock.Unlock()
+	return stop
+}
+
 func (c *Component) initKeyPair() error {
-	priv, err := security.LoadKeypair(c.Config.KeyDir)
+	var priv *ecdsa.PrivateKey
+	var err error
+	if len(c.Config.KeyPEM) > 0 {
+		priv, err = security.LoadKeypairFromPEMWithPassphrase(c.Config.KeyPEM, []byte(c.Config.KeyPassphrase))
+	} else {
+		if err := c.checkKeyPermissions(filepath.Join(c.Config.KeyDir, "server.key")); err != nil {
+			return err
+		}
+		priv, err = security.LoadKeypairWithPassphrase(c.Config.KeyDir, []byte(c.Config.KeyPassphrase))
+	}
 	if err != nil {
 		return err
 	}
+	c.keyLock.Lock()
+	defer c.keyLock.Unlock()
 	c.privateKey = priv
+	c.previousKeys = nil
+	c.publishKeys()
+
+	return nil
+}
 
-	pubPEM, _ := security.PublicPEM(priv)
-	c.Identity.PublicKey = string(pubPEM)
+// checkKeyPermissions checks the permissions of the private key file according to the configured KeyPermissions. A
+// missing file is left to the loader to report.
+func (c *Component) checkKeyPermissions(file string) error {
+	if c.Config.KeyPermissions == KeyPermissionsIgnore {
+		return nil
+	}
+	if _, err := os.Stat(file); err != nil {
+		return nil
+	}
+	err := security.CheckKeyPermissions(file)
+	if err == nil {
+		return nil
+	}
+	if c.Config.KeyPermissions == KeyPermissionsEnforce {
+		return errors.NewErrPermissionDenied(err.Error())
+	}
+	if c.Ctx != nil {
+		c.Ctx.WithError(err).Warn("ttn: Private key is accessible by others, restrict its permissions with chmod 600")
+	}
+	return nil
+}
 
+// previousKey is a key that was replaced by RotateKeyPair, but is still published until it expires
+type previousKey struct {
+	key     *ecdsa.PrivateKey
+	expires time.Time
+}
+
+// RotateKeyPair loads the keypair in the given file and promotes it to the primary key that is used by BuildJWT.
+// The previous key stays published in the Identity for the configured KeyGracePeriod, so that peers can still
+// verify tokens that were signed with it. Call Announce afterwards to publish the new key to the discovery server.
+// The TLS certificate is not affected by a rotation.
+func (c *Component) RotateKeyPair(newKeyPath string) error {
+	if err := c.checkKeyPermissions(newKeyPath); err != nil {
+		return err
+	}
+	priv, err := security.LoadKeypairFileWithPassphrase(newKeyPath, []byte(c.Config.KeyPassphrase))
+	if err != nil {
+		return err
+	}
+	c.rotateKeyPair(priv)
 	return nil
 }
 
+// rotateKeyPair promotes the given key to the primary key, and keeps the previous key published for the KeyGracePeriod
+func (c *Component) rotateKeyPair(priv *ecdsa.PrivateKey) {
+	grace := c.Config.GetKeyGracePeriod()
+
+	c.keyLock.Lock()
+	if c.privateKey != nil {
+		c.previousKeys = append(c.previousKeys, previousKey{
+			key:     c.privateKey,
+			expires: time.Now().Add(grace),
+		})
+	}
+	c.privateKey = priv
+	c.publishKeys()
+	c.InvalidateJWT()
+	c.keyLock.Unlock()
+
+	time.AfterFunc(grace, func() {
+		c.keyLock.Lock()
+		defer c.keyLock.Unlock()
+		c.publishKeys()
+	})
+
+	if c.Ctx != nil {
+		c.Ctx.WithField("GracePeriod", grace).Info("ttn: Rotated keypair")
+	}
+}
+
+// publishKeys removes expired previous keys and sets the PublicKey of the Identity to the PEM-encoded primary key,
+// followed by the previous keys. The caller must hold the keyLock.
+func (c *Component) publishKeys() {
+	var published []byte
+	if pubPEM, err := security.PublicPEM(c.privateKey); err == nil {
+		published = append(published, pubPEM...)
+	}
+	now := time.Now()
+	var keys []previousKey
+	for _, prev := range c.previousKeys {
+		if !now.Before(prev.expires) {
+			continue
+		}
+		keys = append(keys, prev)
+		if pubPEM, err := security.PublicPEM(prev.key); err == nil {
+			published = append(published, pubPEM...)
+		}
+	}
+	c.previousKeys = keys
+	if c.Identity != nil {
+		c.Identity.PublicKey = string(published)
+	}
+}
+
 func (c *Component) initTLS() error {
-	cert, err := security.LoadCert(c.Config.KeyDir)
+	tlsConfig, cert, err := c.loadTLSConfig()
 	if err != nil {
 		return err
 	}
+	c.keyLock.Lock()
 	c.Identity.Certificate = string(cert)
+	c.keyLock.Unlock()
+	c.tlsLock.Lock()
+	c.tlsConfig = tlsConfig
+	c.tlsLock.Unlock()
+	return nil
+}
+
+// loadTLSConfig loads the certificate from the config or the KeyDir, and returns a tls.Config with the certificate and
+// the current private key, along with the PEM-encoded certificate
+func (c *Component) loadTLSConfig() (*tls.Config, []byte, error) {
+	cert := c.Config.CertPEM
+	if len(cert) == 0 {
+		var err error
+		cert, err = security.LoadCert(c.Config.KeyDir)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
 
+	c.keyLock.RLock()
 	privPEM, _ := security.PrivatePEM(c.privateKey)
+	c.keyLock.RUnlock()
 	cer, err := tls.X509KeyPair(cert, privPEM)
 	if err != nil {
+		return nil, nil, err
+	}
+
+	return &tls.Config{Certificates: []tls.Certificate{cer}}, cert, nil
+}
+
+// initClientTLS loads the CA pool from the CAPath into the TLS configuration that is used to dial peers, and disables
+// verification of their certificates if TLSInsecureSkipVerify is set
+func (c *Component) initClientTLS() error {
+	tlsConfig := &tls.Config{}
+	if c.Config.TLSInsecureSkipVerify {
+		tlsConfig.InsecureSkipVerify = true
+		if c.Ctx != nil {
+			c.Ctx.Warn("ttn: TLS certificate verification is DISABLED. Never use this in production!")
+		}
+	}
+	if c.Config.CAPath != "" {
+		roots, err := security.LoadCertPool(c.Config.CAPath)
+		if err != nil {
+			return err
+		}
+		tlsConfig.RootCAs = roots
+	}
+	c.tlsLock.Lock()
+	c.clientTLSConfig = tlsConfig
+	c.tlsLock.Unlock()
+	return nil
+}
+
+// getClientTLSConfig returns the TLS configuration that is used to dial peers. It is nil if InitAuth was not called.
+func (c *Component) getClientTLSConfig() *tls.Config {
+	c.tlsLock.RLock()
+	defer c.tlsLock.RUnlock()
+	return c.clientTLSConfig
+}
+
+// ReloadTLSCertificate loads the certificate and the private key from the KeyDir again, so that a renewed certificate
+// can be used without a restart. If the certificate was issued for a new key, the new key is rotated in like with
+// RotateKeyPair. Connections that are already established keep using the old certificate, new connections use the new
+// one. The new certificate is announced to the discovery server if one is configured. Certificates and keys that are
+// configured as PEM can not be reloaded.
+func (c *Component) ReloadTLSCertificate() error {
+	if !c.Config.UseTLS {
+		return errors.NewErrInvalidArgument("TLS", "is not enabled")
+	}
+	if len(c.Config.CertPEM) > 0 || len(c.Config.KeyPEM) > 0 {
+		return errors.NewErrInvalidArgument("TLS", "certificate or key is configured as PEM and can not be reloaded")
+	}
+	if err := c.checkKeyPermissions(filepath.Join(c.Config.KeyDir, "server.key")); err != nil {
 		return err
 	}
+	priv, err := security.LoadKeypairWithPassphrase(c.Config.KeyDir, []byte(c.Config.KeyPassphrase))
+	if err != nil {
+		return errors.Wrap(err, "Could not reload private key")
+	}
+	cert, err := security.LoadCert(c.Config.KeyDir)
+	if err != nil {
+		return errors.Wrap(err, "Could not reload TLS certificate")
+	}
+	privPEM, err := security.PrivatePEM(priv)
+	if err != nil {
+		return errors.Wrap(err, "Could not reload private key")
+	}
+	cer, err := tls.X509KeyPair(cert, privPEM)
+	if err != nil {
+		return errors.Wrap(err, "Could not reload TLS certificate")
+	}
 
+	c.keyLock.RLock()
+	newKey := c.privateKey == nil || c.privateKey.D.Cmp(priv.D) != 0
+	c.keyLock.RUnlock()
+	if newKey {
+		c.rotateKeyPair(priv)
+	}
+	c.keyLock.Lock()
+	c.Identity.Certificate = string(cert)
+	c.keyLock.Unlock()
+	c.tlsLock.Lock()
 	c.tlsConfig = &tls.Config{Certificates: []tls.Certificate{cer}}
+	c.tlsLock.Unlock()
+
+	if c.Ctx != nil {
+		c.Ctx.Info("ttn: Reloaded TLS certificate")
+	}
+	if c.Discovery != nil {
+		return c.Announce()
+	}
 	return nil
 }
 
-// BuildJWT builds a short-lived JSON Web Token for this component
+// getTLSConfig returns the current TLS configuration of the component. It is nil if TLS is not used.
+func (c *Component) getTLSConfig() *tls.Config {
+	c.tlsLock.RLock()
+	defer c.tlsLock.RUnlock()
+	return c.tlsConfig
+}
+
+// getTLSCertificate returns the current TLS certificate of the component to the TLS server
+func (c *Component) getTLSCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	tlsConfig := c.getTLSConfig()
+	if tlsConfig == nil || len(tlsConfig.Certificates) == 0 {
+		return nil, errors.New("No TLS certificate loaded")
+	}
+	return &tlsConfig.Certificates[0], nil
+}
+
+// JWTRenewMargin is the remaining validity below which a cached token is no longer reused by BuildJWT
+var JWTRenewMargin = 5 * time.Second
+
+type jwtCache struct {
+	sync.Mutex
+	token   string
+	subject string
+	ttl     time.Duration
+	expires time.Time
+}
+
+// BuildJWT builds a short-lived JSON Web Token for this component. The token is cached and reused until it is about
+// to expire, or until the component ID or TokenTTL change. If SingleUseTokens is set, a new token is built for every
+// call. The kid header of the token is the security.KeyID of the current key, so that peers can select it from the
+// keys that are published in the Identity. Tokens are issued at the time of the Clock of the component.
 func (c *Component) BuildJWT() (string, error) {
-	if c.privateKey != nil {
-		privPEM, err := security.PrivatePEM(c.privateKey)
-		if err != nil {
-			return "", err
-		}
-		return security.BuildJWT(c.Identity.Id, 20*time.Second, privPEM)
+	c.keyLock.RLock()
+	defer c.keyLock.RUnlock()
+
+	if c.privateKey == nil {
+		return "", nil
+	}
+
+	clock := c.clock()
+	ttl := c.Config.GetTokenTTL()
+	margin := JWTRenewMargin
+	if margin > ttl/2 {
+		margin = ttl / 2
 	}
-	return "", nil
+
+	c.jwtCache.Lock()
+	defer c.jwtCache.Unlock()
+
+	if !c.Config.SingleUseTokens && c.jwtCache.token != "" && c.jwtCache.subject == c.Identity.Id && c.jwtCache.ttl == ttl &&
+		clock.Now().Add(margin).Before(c.jwtCache.expires) {
+		return c.jwtCache.token, nil
+	}
+
+	privPEM, err := security.PrivatePEM(c.privateKey)
+	if err != nil {
+		return "", err
+	}
+	pubPEM, err := security.PublicPEM(c.privateKey)
+	if err != nil {
+		return "", err
+	}
+	keyID, err := security.KeyID(pubPEM)
+	if err != nil {
+		return "", err
+	}
+	expires := clock.Now().Add(ttl)
+	token, err := security.BuildJWTWithClock(clock, c.Identity.Id, ttl, privPEM, keyID)
+	if err != nil {
+		return "", err
+	}
+
+	c.jwtCache.token = token
+	c.jwtCache.subject = c.Identity.Id
+	c.jwtCache.ttl = ttl
+	c.jwtCache.expires = expires
+
+	return token, nil
+}
+
+// InvalidateJWT makes sure that the next call to BuildJWT builds a new token
+func (c *Component) InvalidateJWT() {
+	c.jwtCache.Lock()
+	defer c.jwtCache.Unlock()
+	c.jwtCache.token = ""
 }
 
 // GetContext returns a context for outgoing RPC request. If token is "", this function will generate a short lived token from the component
 func (c *Component) GetContext(token string) context.Context {
+	return c.GetContextWithParent(context.Background(), token)
+}
+
+// GetContextForwarding returns a context for outgoing RPC request like GetContext, but forwards the token of the
+// given inbound context, so that the identity of the original caller is preserved. If the inbound context does not
+// have exactly one token, this function falls back to a short lived token from the component.
+func (c *Component) GetContextForwarding(inbound context.Context) context.Context {
+	var token string
+	if md, err := api.MetadataFromContext(inbound); err == nil {
+		token = api.ComponentMetadataFromMD(md).Token
+	}
+	return c.GetContext(token)
+}
+
+// GetContextWithParent returns a context for outgoing RPC request like GetContext, but derives it from the given parent
+// context, so that cancellation and deadlines of the parent apply to the request. Metadata of the parent context is
+// kept, except for the auth metadata that is set by this function.
+func (c *Component) GetContextWithParent(parent context.Context, token string) context.Context {
 	var serviceName, id, netAddress string
 	if c.Identity != nil {
 		serviceName = c.Identity.ServiceName
 		id = c.Identity.Id
 		if token == "" {
 			token, _ = c.BuildJWT()
+			if c.Ctx != nil {
+				c.Ctx.WithFields(log.Fields{
+					"TTL":   c.Config.GetTokenTTL(),
+					"Token": security.Redact(token),
+				}).Debug("ttn: Generated short-lived token for outgoing request")
+			}
 		}
 		netAddress = c.Identity.NetAddress
 	}
-	md := metadata.Pairs(
-		"service-name", serviceName,
-		"id", id,
-		"token", token,
-		"net-address", netAddress,
-	)
-	ctx := metadata.NewContext(context.Background(), md)
+	md := api.ComponentMetadata{
+		ServiceName: serviceName,
+		ID:          id,
+		Token:       token,
+		NetAddress:  netAddress,
+	}.MD()
+	if parentMD, ok := metadata.FromContext(parent); ok {
+		merged := parentMD.Copy()
+		for k, v := range md {
+			merged[k] = v
+		}
+		md = merged
+	}
+	ctx := metadata.NewContext(parent, md)
 	return ctx
 }
 
-// ExchangeAppKeyForToken enables authentication with the App Access Key
+// SignRequest sets the same auth metadata that GetContext sets for RPC requests on the headers of an outgoing HTTP
+// request. The short-lived token of the component is sent as bearer token. Peers can get the metadata with
+// api.ComponentMetadataFromHeader and validate it with ValidateNetworkContext.
+func (c *Component) SignRequest(req *http.Request) error {
+	if c.Identity == nil {
+		return errors.NewErrInternal("Component has no identity")
+	}
+	token, err := c.BuildJWT()
+	if err != nil {
+		return errors.Wrap(err, "Could not build token")
+	}
+	api.ComponentMetadata{
+		ServiceName: c.Identity.ServiceName,
+		ID:          c.Identity.Id,
+		Token:       token,
+		NetAddress:  c.Identity.NetAddress,
+	}.SetHeader(req.Header)
+	return nil
+}
+
+// ExchangeAppKeyForToken enables authentication with the App Access Key. Tokens are cached per appID and key until
+// they are within the configured OAuthTokenRenewMargin of their expiry.
 func (c *Component) ExchangeAppKeyForToken(appID, key string) (string, error) {
+	return c.ExchangeAppKeyForTokenWithScope(appID, key, nil)
+}
+
+// ExchangeAppKeyForTokenWithScope is like ExchangeAppKeyForToken, but requests a token that is restricted to the given
+// scopes. If no scopes are given, the token gets the default scope of the auth server.
+func (c *Component) ExchangeAppKeyForTokenWithScope(appID, key string, scopes []string) (string, error) {
 	issuerID := keys.KeyIssuer(key)
 	if issuerID == "" {
 		// Take the first configured auth server
@@ -176,99 +784,483 @@ func (c *Component) ExchangeAppKeyForToken(appID, key string) (string, error) {
 	}
 	issuer, ok := c.Config.AuthServers[issuerID]
 	if !ok {
-		return "", fmt.Errorf("Auth server %s not registered", issuer)
+		return "", fmt.Errorf("Auth server %s not registered", issuerID)
+	}
+
+	cacheKey := appID + "\x00" + key + "\x00" + strings.Join(scopes, " ")
+	if token := c.tokenCache.get(cacheKey, c.Config.GetOAuthTokenRenewMargin()); token != nil {
+		return token.AccessToken, nil
 	}
 
 	srv, _ := parseAuthServer(issuer)
+	username, password, err := srv.credentials()
+	if err != nil {
+		return "", errors.Wrapf(err, "Could not resolve credentials for auth server %s", issuerID)
+	}
+
+	client := c.oauthClient(srv.url, username, password, scopes)
 
-	oauth := oauth.OAuth(srv.url, &oauth.Client{
-		ID:     srv.username,
-		Secret: srv.password,
+	if c.Ctx != nil {
+		c.Ctx.WithFields(log.Fields{
+			"AppID":  appID,
+			"Key":    security.Redact(key),
+			"Scopes": scopes,
+		}).Debug("ttn: Exchanging app key for token")
+	}
+
+	timeout := c.Config.GetOAuthTimeout()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{
+		Transport: c.authServerClient(issuerID).Transport,
+		Timeout:   timeout,
 	})
 
-	token, err := oauth.ExchangeAppKeyForToken(appID, key)
+	// This is the same password grant as oauth.ExchangeAppKeyForToken, but with a deadline
+	start := time.Now()
+	token, err := client.PasswordCredentialsToken(ctx, appID, key)
+	c.observeLatency(LatencyOAuth, start)
 	if err != nil {
+		if ctx.Err() != nil || time.Since(start) >= timeout {
+			return "", errors.NewErrUnavailable(fmt.Sprintf("Auth server %s did not respond within %s", issuerID, timeout))
+		}
 		return "", err
 	}
 
+	c.tokenCache.set(cacheKey, token)
+
 	return token.AccessToken, nil
 }
 
+// ClearTokenCache removes all tokens that were cached by ExchangeAppKeyForToken
+func (c *Component) ClearTokenCache() {
+	c.tokenCache.clear()
+}
+
 // ValidateNetworkContext validates the context of a network request (router-broker, broker-handler, etc)
 func (c *Component) ValidateNetworkContext(ctx context.Context) (component *pb_discovery.Announcement, err error) {
+	component, _, err = c.ValidateNetworkContextWithClaims(ctx)
+	return
+}
+
+// isTrustedIssuer returns true if the issuer is in the configured TrustedIssuers, or if no TrustedIssuers are configured
+func (c *Component) isTrustedIssuer(issuer string) bool {
+	if len(c.Config.TrustedIssuers) == 0 {
+		return true
+	}
+	for _, trusted := range c.Config.TrustedIssuers {
+		if issuer == trusted {
+			return true
+		}
+	}
+	return false
+}
+
+// isAllowedServiceName returns true if the service name matches one of the configured AllowedServiceNames, or if no
+// AllowedServiceNames are configured
+func (c *Component) isAllowedServiceName(serviceName string) bool {
+	if len(c.Config.AllowedServiceNames) == 0 {
+		return true
+	}
+	for _, pattern := range c.Config.AllowedServiceNames {
+		if matched, _ := path.Match(pattern, serviceName); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// isAnnouncedNetAddress returns true if all addresses in the comma-separated netAddress are in the NetAddress of the
+// announcement
+func isAnnouncedNetAddress(announcement *pb_discovery.Announcement, netAddress string) bool {
+	announced := make(map[string]bool)
+	for _, addr := range strings.Split(announcement.NetAddress, ",") {
+		announced[strings.TrimSpace(addr)] = true
+	}
+	for _, addr := range strings.Split(netAddress, ",") {
+		if !announced[strings.TrimSpace(addr)] {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateNetworkContextWithClaims validates the context of a network request like ValidateNetworkContext, and also
+// returns the claims of the token. The claims are nil if the announcement of the peer has no public key. Such peers are
+// rejected if RequireAuthenticatedPeers is set.
+func (c *Component) ValidateNetworkContextWithClaims(ctx context.Context) (component *pb_discovery.Announcement, claims *jwt.StandardClaims, err error) {
+	var id, serviceName, token, netAddress string
+	var announcement *pb_discovery.Announcement
+
+	end, err := c.beginValidation()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer end()
+
+	// Only failures of peers that were discovered are counted, so that unknown ids do not fill the limiter, and
+	// failures of the Discovery server or of this component are not held against the peer
 	defer func() {
-		if err != nil {
-			time.Sleep(time.Second)
+		if err == nil || announcement == nil {
+			return
+		}
+		switch errors.GetErrType(err) {
+		case errors.Unavailable, errors.Internal:
+			return
 		}
+		c.getValidationLimiter().Fail(validationLimiterKey(ctx, serviceName, id))
 	}()
 
 	md, ok := metadata.FromContext(ctx)
 	if !ok {
+		c.AuthCounter("network", "", AuthInvalidMetadata).Inc(1)
 		err = errors.NewErrInternal("Could not get metadata from context")
 		return
 	}
-	var id, serviceName, token string
-	if ids, ok := md["id"]; ok && len(ids) == 1 {
-		id = ids[0]
-	}
+	meta := api.ComponentMetadataFromMD(md)
+	serviceName, id, token, netAddress = meta.ServiceName, meta.ID, meta.Token, meta.NetAddress
 	if id == "" {
+		c.AuthCounter("network", metricServiceName(serviceName), AuthInvalidMetadata).Inc(1)
 		err = errors.NewErrInvalidArgument("Metadata", "id missing")
 		return
 	}
-	if serviceNames, ok := md["service-name"]; ok && len(serviceNames) == 1 {
-		serviceName = serviceNames[0]
-	}
 	if serviceName == "" {
+		c.AuthCounter("network", metricServiceName(serviceName), AuthInvalidMetadata).Inc(1)
 		err = errors.NewErrInvalidArgument("Metadata", "service-name missing")
 		return
 	}
-	if tokens, ok := md["token"]; ok && len(tokens) == 1 {
-		token = tokens[0]
+	if c.getValidationLimiter().Throttled(validationLimiterKey(ctx, serviceName, id)) {
+		c.AuthCounter("network", metricServiceName(serviceName), AuthThrottled).Inc(1)
+		err = errors.NewErrPermissionDenied(fmt.Sprintf("Too many failed validations for %s", id))
+		return
+	}
+	if !c.isAllowedServiceName(serviceName) {
+		c.AuthCounter("network", metricServiceName(serviceName), AuthUntrustedService).Inc(1)
+		err = errors.NewErrPermissionDenied(fmt.Sprintf("Service %s is not allowed", serviceName))
+		return
 	}
 
-	var announcement *pb_discovery.Announcement
-	announcement, err = c.Discover(serviceName, id)
+	announcement, err = c.DiscoverWithContext(ctx, serviceName, id)
 	if err != nil {
+		c.AuthCounter("network", metricServiceName(serviceName), AuthDiscoveryError).Inc(1)
+		return
+	}
+
+	// Tokens must be issued by the peer itself, so this also rejects peers that do not announce a public key
+	if !c.isTrustedIssuer(id) {
+		c.AuthCounter("network", serviceName, AuthUntrustedIssuer).Inc(1)
+		err = errors.NewErrPermissionDenied(fmt.Sprintf("Issuer %s is not trusted", id))
+		return
+	}
+
+	if c.Config.UseTLS && c.Config.RequireAnnouncementCertificate {
+		if err = c.ValidateAnnouncementCertificate(announcement); err != nil {
+			// The peer may have renewed its certificate, so the announcement is discovered again on the next validation
+			c.invalidateAnnouncement(serviceName, id)
+			c.AuthCounter("network", serviceName, AuthBadCertificate).Inc(1)
+			return
+		}
+	}
+
+	if c.Config.VerifyNetAddress && netAddress != "" && !isAnnouncedNetAddress(announcement, netAddress) {
+		// The peer may have moved, so the announcement is discovered again on the next validation
+		c.invalidateAnnouncement(serviceName, id)
+		c.AuthCounter("network", serviceName, AuthWrongNetAddress).Inc(1)
+		err = errors.NewErrPermissionDenied(fmt.Sprintf("Net address %s is not announced by %s %s", netAddress, serviceName, id))
 		return
 	}
 
 	if announcement.PublicKey == "" {
-		return announcement, nil
+		if c.Config.RequireAuthenticatedPeers {
+			c.AuthCounter("network", serviceName, AuthMissingKey).Inc(1)
+			err = errors.NewErrPermissionDenied(fmt.Sprintf("%s %s does not announce a public key", serviceName, id))
+			return
+		}
+		c.AuthCounter("network", serviceName, AuthSuccess).Inc(1)
+		return announcement, nil, nil
 	}
 
 	if token == "" {
+		c.AuthCounter("network", serviceName, AuthMissingToken).Inc(1)
 		err = errors.NewErrInvalidArgument("Metadata", "token missing")
 		return
 	}
 
-	var claims *jwt.StandardClaims
-	claims, err = security.ValidateJWT(token, []byte(announcement.PublicKey))
+	var tokenClaims *jwt.StandardClaims
+	start := time.Now()
+	tokenClaims, err = security.ValidateJWTWithClock(c.clock(), token, []byte(announcement.PublicKey), c.Config.ClockSkew)
+	c.observeLatency(LatencyJWT, start)
 	if err != nil {
+		// The peer may have rotated its key, so the announcement is discovered again on the next validation
+		c.invalidateAnnouncement(serviceName, id)
+		c.AuthCounter("network", serviceName, AuthBadSignature).Inc(1)
+		if c.Ctx != nil {
+			// The claims are not trusted, they only help to find out who sent the token
+			if unverified, parseErr := security.ParseUnverified(token); parseErr == nil {
+				c.Ctx.WithFields(log.Fields{
+					"ID":                id,
+					"ServiceName":       serviceName,
+					"UnverifiedIssuer":  unverified.Issuer,
+					"UnverifiedSubject": unverified.Subject,
+				}).WithError(err).Debug("ttn: Token of component failed validation")
+			}
+		}
 		return
 	}
-	if claims.Issuer != id {
+	if tokenClaims.Issuer != id {
+		c.AuthCounter("network", serviceName, AuthWrongIssuer).Inc(1)
 		err = errors.NewErrInvalidArgument("Metadata", "token was issued by different component id")
 		return
 	}
 
-	return announcement, nil
+	if c.Config.SingleUseTokens {
+		if tokenClaims.Id == "" || tokenClaims.ExpiresAt == 0 {
+			c.AuthCounter("network", serviceName, AuthInvalidMetadata).Inc(1)
+			err = errors.NewErrInvalidArgument("Metadata", "token has no ID or expiry")
+			return
+		}
+		expires := time.Unix(tokenClaims.ExpiresAt, 0).Add(c.Config.ClockSkew)
+		if !c.getReplayCache().Use(tokenClaims.Issuer+"\x00"+tokenClaims.Id, expires, c.clock().Now()) {
+			c.AuthCounter("network", serviceName, AuthReplayed).Inc(1)
+			err = errors.NewErrPermissionDenied("Token was already used")
+			return
+		}
+	}
+
+	c.AuthCounter("network", serviceName, AuthSuccess).Inc(1)
+	return announcement, tokenClaims, nil
+}
+
+// clock returns the configured Clock, or the wall clock if none is configured
+func (c *Component) clock() security.Clock {
+	if c.Clock == nil {
+		return security.RealClock
+	}
+	return c.Clock
+}
+
+func (c *Component) getReplayCache() *replayCache {
+	c.replayCacheOnce.Do(func() {
+		c.replayCache = newReplayCache()
+	})
+	return c.replayCache
+}
+
+func (c *Component) getValidationLimiter() *failureLimiter {
+	c.validationLimiterOnce.Do(func() {
+		c.validationLimiter = newFailureLimiter(FailureLimiterSize, c.Config.GetFailedValidationBurst(), c.Config.GetFailedValidationInterval())
+	})
+	return c.validationLimiter
 }
 
-// ValidateTTNAuthContext gets a token from the context and validates it
+// validationLimiterKey returns the key of the failed validations of the peer. It includes the remote host of the
+// request, so that invalid requests with the id of a peer from elsewhere do not throttle the peer itself.
+func validationLimiterKey(ctx context.Context, serviceName, id string) string {
+	key := serviceName + "/" + id
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		host, _, err := net.SplitHostPort(p.Addr.String())
+		if err != nil {
+			host = p.Addr.String()
+		}
+		key += "@" + host
+	}
+	return key
+}
+
+// ClaimsFromToken verifies the given token with the keys of the TokenKeyProvider and returns its claims. It is safe to
+// call while the keys are updated with UpdateTokenKey. Tokens are verified with the key of the auth server in their
+// issuer claim, and tokens of issuers that are not configured are rejected. If the token has no issuer, the keys of all
+// auth servers are tried in order of their ID, and the issuer of the claims is set to the auth server that verified it.
+func (c *Component) ClaimsFromToken(token string) (*claims.Claims, error) {
+	c.tokenKeyLock.RLock()
+	defer c.tokenKeyLock.RUnlock()
+	if c.TokenKeyProvider == nil {
+		return nil, errors.NewErrInternal("No token provider configured")
+	}
+	defer c.observeLatency(LatencyTTNToken, time.Now())
+
+	var issuer string
+	if unverified, err := security.ParseUnverified(token); err == nil {
+		issuer = unverified.Issuer
+	}
+	if _, ok := c.Config.AuthServers[issuer]; ok || len(c.Config.AuthServers) == 0 {
+		return claims.FromToken(c.TokenKeyProvider, token)
+	}
+
+	// Otherwise any configured auth server could issue tokens in the name of another one
+	if issuer != "" {
+		return nil, fmt.Errorf("Token issuer %s is not a configured auth server", issuer)
+	}
+
+	// The token has no issuer, so we try the keys of all auth servers
+	ids := make([]string, 0, len(c.Config.AuthServers))
+	for id := range c.Config.AuthServers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	failures := make([]string, 0, len(ids))
+	for _, id := range ids {
+		key, err := c.TokenKeyProvider.Get(id, false)
+		if err == nil {
+			var tokenClaims *claims.Claims
+			if tokenClaims, err = claimsFromTokenWithKey(token, key); err == nil {
+				tokenClaims.Issuer = id
+				return tokenClaims, nil
+			}
+		}
+		failures = append(failures, fmt.Sprintf("%s: %s", id, err.Error()))
+	}
+	return nil, fmt.Errorf("Token could not be validated by any auth server (%s)", strings.Join(failures, "; "))
+}
+
+// claimsFromTokenWithKey verifies the token with the given key and returns its claims
+func claimsFromTokenWithKey(token string, key *tokenkey.TokenKey) (*claims.Claims, error) {
+	tokenClaims := &claims.Claims{}
+	_, err := jwt.ParseWithClaims(token, tokenClaims, func(parsed *jwt.Token) (interface{}, error) {
+		if parsed.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("Expected algorithm %s but got %s", key.Algorithm, parsed.Method.Alg())
+		}
+		switch parsed.Method.(type) {
+		case *jwt.SigningMethodRSA:
+			return jwt.ParseRSAPublicKeyFromPEM([]byte(key.Key))
+		case *jwt.SigningMethodECDSA:
+			return jwt.ParseECPublicKeyFromPEM([]byte(key.Key))
+		}
+		return nil, fmt.Errorf("Unexpected signing method %s", parsed.Method.Alg())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tokenClaims, nil
+}
+
+// ValidateTTNAuthContext gets a token from the context and validates it. Use security.TimeUntilExpiry on the
+// StandardClaims of the result to get the remaining validity of the token.
 func (c *Component) ValidateTTNAuthContext(ctx context.Context) (*claims.Claims, error) {
-	token, err := api.TokenFromContext(ctx)
+	end, err := c.beginValidation()
 	if err != nil {
 		return nil, err
 	}
+	defer end()
 
-	if c.TokenKeyProvider == nil {
-		return nil, errors.NewErrInternal("No token provider configured")
+	// The service name is not validated for TTN tokens, so only known service names are counted
+	var serviceName string
+	if md, err := api.MetadataFromContext(ctx); err == nil {
+		serviceName = metricServiceName(api.ComponentMetadataFromMD(md).ServiceName)
 	}
 
-	claims, err := claims.FromToken(c.TokenKeyProvider, token)
+	token, err := api.TokenFromContextWithMaxLength(ctx, c.Config.GetMaxTokenLength())
+	if err == api.ErrTokenTooLong {
+		c.AuthCounter("ttn", serviceName, AuthTokenTooLong).Inc(1)
+		return nil, err
+	}
 	if err != nil {
+		c.AuthCounter("ttn", serviceName, AuthMissingToken).Inc(1)
+		return nil, err
+	}
+
+	claims, err := c.ClaimsFromToken(token)
+	if errors.GetErrType(err) == errors.Internal {
+		return nil, err
+	}
+	if err != nil {
+		c.AuthCounter("ttn", serviceName, AuthBadSignature).Inc(1)
 		return nil, errors.NewErrPermissionDenied(err.Error())
 	}
 
+	if c.Config.ExpectedAudience != "" && claims.Audience != c.Config.ExpectedAudience {
+		c.AuthCounter("ttn", serviceName, AuthWrongAudience).Inc(1)
+		return nil, errors.NewErrPermissionDenied(fmt.Sprintf("Token audience \"%s\" does not match \"%s\"", claims.Audience, c.Config.ExpectedAudience))
+	}
+
+	if c.RevocationChecker != nil && claims.Id != "" {
+		revoked, err := c.RevocationChecker.IsRevoked(claims.Id)
+		if err != nil {
+			if !c.Config.RevocationFailOpen {
+				return nil, errors.NewErrInternal(fmt.Sprintf("Could not check token revocation: %s", err.Error()))
+			}
+			if c.Ctx != nil {
+				c.Ctx.WithError(err).Warn("ttn: Could not check token revocation, accepting token")
+			}
+		}
+		if revoked {
+			c.AuthCounter("ttn", serviceName, AuthRevoked).Inc(1)
+			return nil, errors.NewErrPermissionDenied("Token has been revoked")
+		}
+	}
+
+	c.AuthCounter("ttn", serviceName, AuthSuccess).Inc(1)
 	return claims, nil
 }
+
+// AppsFromContext validates the context like ValidateTTNAuthContext and returns the sorted IDs of the applications that
+// the token grants rights to
+func (c *Component) AppsFromContext(ctx context.Context) ([]string, error) {
+	claims, err := c.ValidateTTNAuthContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(claims.Apps) == 0 {
+		return nil, errors.NewErrPermissionDenied("Token does not grant rights to any application")
+	}
+	apps := make([]string, 0, len(claims.Apps))
+	for appID := range claims.Apps {
+		apps = append(apps, appID)
+	}
+	sort.Strings(apps)
+	return apps, nil
+}
+
+// deviceClaims are the device restrictions of a token. The devices claim maps application IDs to the IDs of the
+// devices that the token is restricted to. Applications that are not in the claim are not restricted.
+type deviceClaims struct {
+	Devices map[string][]string `json:"devices,omitempty"`
+}
+
+// DeviceAllowed validates the context like ValidateTTNAuthContext and checks that the token grants rights to the
+// devices of the application, and that it is not restricted to other devices of that application by its devices
+// claim. If the device is not allowed, it returns a permission denied error that says why.
+func (c *Component) DeviceAllowed(ctx context.Context, appID, devID string) (bool, error) {
+	claims, err := c.ValidateTTNAuthContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	if !claims.AppRight(appID, rights.Devices) {
+		return false, errors.NewErrPermissionDenied(fmt.Sprintf("Token does not grant rights to the devices of application %s", appID))
+	}
+
+	// The signature of the token was verified above and covers the claims segment, so the devices claim can be
+	// decoded from the same token without verifying it again
+	token, err := api.TokenFromContextWithMaxLength(ctx, c.Config.GetMaxTokenLength())
+	if err != nil {
+		return false, err
+	}
+	restrictions := deviceClaims{}
+	if err := decodeTokenClaims(token, &restrictions); err != nil {
+		return false, errors.NewErrPermissionDenied(fmt.Sprintf("Could not read device restrictions: %s", err.Error()))
+	}
+	devices, restricted := restrictions.Devices[appID]
+	if !restricted {
+		return true, nil
+	}
+	for _, allowed := range devices {
+		if allowed == devID {
+			return true, nil
+		}
+	}
+	return false, errors.NewErrPermissionDenied(fmt.Sprintf("Token is restricted to other devices of application %s", appID))
+}
+
+// decodeTokenClaims decodes the claims segment of the token into v, without verifying the token. Only use it on tokens
+// that were validated already.
+func decodeTokenClaims(token string, v interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("token does not contain three segments")
+	}
+	data, err := jwt.DecodeSegment(parts[1])
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,68 @@
+package component
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+
+	pb_discovery "github.com/TheThingsNetwork/ttn/api/discovery"
+	"github.com/TheThingsNetwork/ttn/utils/errors"
+	"github.com/TheThingsNetwork/ttn/utils/security"
+)
+
+// ValidateAnnouncementCertificate verifies the Certificate of the announcement against the configured CAPath, or the
+// system CAs if no CAPath is configured, and checks that the public key of the certificate is one of the keys in the
+// PublicKey of the announcement.
+func (c *Component) ValidateAnnouncementCertificate(ann *pb_discovery.Announcement) error {
+	if ann.Certificate == "" {
+		return errors.NewErrInvalidArgument("Certificate", "announcement has no certificate")
+	}
+	block, _ := pem.Decode([]byte(ann.Certificate))
+	if block == nil {
+		return errors.NewErrInvalidArgument("Certificate", "no PEM data found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return errors.NewErrInvalidArgument("Certificate", err.Error())
+	}
+
+	roots, err := c.rootCAs()
+	if err != nil {
+		return err
+	}
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return errors.NewErrPermissionDenied(err.Error())
+	}
+
+	certKey, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return errors.NewErrInvalidArgument("Certificate", err.Error())
+	}
+	// During a key rotation, the certificate may still contain one of the previous keys
+	for rest := []byte(ann.PublicKey); ; {
+		var keyBlock *pem.Block
+		keyBlock, rest = pem.Decode(rest)
+		if keyBlock == nil {
+			break
+		}
+		if bytes.Equal(keyBlock.Bytes, certKey) {
+			return nil
+		}
+	}
+	return errors.NewErrPermissionDenied("Certificate does not match the announced public key")
+}
+
+// rootCAs returns the CA pool to verify peers against. It is nil if the system CAs should be used
+func (c *Component) rootCAs() (*x509.CertPool, error) {
+	if tlsConfig := c.getClientTLSConfig(); tlsConfig != nil && tlsConfig.RootCAs != nil {
+		return tlsConfig.RootCAs, nil
+	}
+	if c.Config.CAPath == "" {
+		return nil, nil
+	}
+	return security.LoadCertPool(c.Config.CAPath)
+}
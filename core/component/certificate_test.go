@@ -0,0 +1,99 @@
+package component
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/TheThingsNetwork/ttn/api/discovery"
+	errs "github.com/TheThingsNetwork/ttn/utils/errors"
+	"github.com/TheThingsNetwork/ttn/utils/security"
+	"github.com/golang/mock/gomock"
+	"github.com/smartystreets/assertions"
+)
+
+func buildTestAnnouncement(t *testing.T, dir string) *discovery.Announcement {
+	if err := security.GenerateKeypair(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := security.GenerateCert(dir, "localhost"); err != nil {
+		t.Fatal(err)
+	}
+	pub, _ := ioutil.ReadFile(dir + "/server.pub")
+	cert, _ := ioutil.ReadFile(dir + "/server.cert")
+	return &discovery.Announcement{Id: "test-cert", ServiceName: "test-service", PublicKey: string(pub), Certificate: string(cert)}
+}
+
+func TestValidateAnnouncementCertificate(t *testing.T) {
+	a := assertions.New(t)
+
+	peerDir, _ := ioutil.TempDir("", "ttn-peer")
+	defer os.RemoveAll(peerDir)
+	otherDir, _ := ioutil.TempDir("", "ttn-other")
+	defer os.RemoveAll(otherDir)
+	peer := buildTestAnnouncement(t, peerDir)
+	other := buildTestAnnouncement(t, otherDir)
+
+	c := new(Component)
+	c.Config.CAPath = peerDir + "/server.cert"
+
+	// Matching chain
+	a.So(c.ValidateAnnouncementCertificate(peer), assertions.ShouldBeNil)
+
+	// Certificate of a previous key during a rotation
+	rotated := *peer
+	rotated.PublicKey = other.PublicKey + peer.PublicKey
+	a.So(c.ValidateAnnouncementCertificate(&rotated), assertions.ShouldBeNil)
+
+	// Mismatched key and certificate
+	mismatched := *peer
+	mismatched.PublicKey = other.PublicKey
+	err := c.ValidateAnnouncementCertificate(&mismatched)
+	a.So(err, assertions.ShouldNotBeNil)
+	a.So(errs.GetErrType(err), assertions.ShouldEqual, errs.PermissionDenied)
+
+	// Certificate that is not signed by the CA
+	err = c.ValidateAnnouncementCertificate(other)
+	a.So(err, assertions.ShouldNotBeNil)
+	a.So(errs.GetErrType(err), assertions.ShouldEqual, errs.PermissionDenied)
+
+	// Missing or invalid certificate
+	a.So(c.ValidateAnnouncementCertificate(&discovery.Announcement{PublicKey: peer.PublicKey}), assertions.ShouldNotBeNil)
+	a.So(c.ValidateAnnouncementCertificate(&discovery.Announcement{Certificate: "not a cert"}), assertions.ShouldNotBeNil)
+}
+
+func TestValidateNetworkContextRequireCertificate(t *testing.T) {
+	a := assertions.New(t)
+
+	peerDir, _ := ioutil.TempDir("", "ttn-peer")
+	defer os.RemoveAll(peerDir)
+	otherDir, _ := ioutil.TempDir("", "ttn-other")
+	defer os.RemoveAll(otherDir)
+	buildTestAnnouncement(t, otherDir)
+
+	c := new(Component)
+	c.Identity = buildTestAnnouncement(t, peerDir)
+	c.Config.KeyDir = peerDir
+	c.Config.CAPath = peerDir + "/server.cert"
+	c.Config.UseTLS = true
+	c.Config.RequireAnnouncementCertificate = true
+	a.So(c.initKeyPair(), assertions.ShouldBeNil)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	discoveryClient := discovery.NewMockClient(ctrl)
+	c.Discovery = discoveryClient
+
+	discoveryClient.EXPECT().Get("test-service", "test-cert").Return(c.Identity, nil)
+	_, err := c.ValidateNetworkContext(c.GetContext(""))
+	a.So(err, assertions.ShouldBeNil)
+
+	// The announcement is discovered again after the certificate is rejected
+	c.Config.CAPath = otherDir + "/server.cert"
+	_, err = c.ValidateNetworkContext(c.GetContext(""))
+	a.So(err, assertions.ShouldNotBeNil)
+	discoveryClient.EXPECT().Get("test-service", "test-cert").Return(c.Identity, nil)
+	c.Config.CAPath = peerDir + "/server.cert"
+	_, err = c.ValidateNetworkContext(c.GetContext(""))
+	a.So(err, assertions.ShouldBeNil)
+}
@@ -8,16 +8,22 @@ import (
 	"crypto/ecdsa"
 	"crypto/tls"
 	"fmt"
+	"net"
 	"net/http"
 	"runtime"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/TheThingsNetwork/go-account-lib/claims"
 	"github.com/TheThingsNetwork/go-account-lib/tokenkey"
 	pb_discovery "github.com/TheThingsNetwork/ttn/api/discovery"
 	pb_monitor "github.com/TheThingsNetwork/ttn/api/monitor"
+	"github.com/TheThingsNetwork/ttn/utils/errors"
 	"github.com/TheThingsNetwork/ttn/utils/logging"
+	"github.com/TheThingsNetwork/ttn/utils/security"
 	"github.com/apex/log"
+	"github.com/rcrowley/go-metrics"
 	"github.com/spf13/viper"
 	"golang.org/x/net/context" // See https://github.com/grpc/grpc-go/issues/711"
 	"google.golang.org/grpc"
@@ -26,16 +32,50 @@ import (
 
 // Component contains the common attributes for all TTN components
 type Component struct {
-	Config           Config
-	Identity         *pb_discovery.Announcement
-	Discovery        pb_discovery.Client
-	Monitors         map[string]*pb_monitor.Client
-	Ctx              log.Interface
-	AccessToken      string
-	privateKey       *ecdsa.PrivateKey
-	tlsConfig        *tls.Config
-	TokenKeyProvider tokenkey.Provider
-	status           int64
+	Config            Config
+	Identity          *pb_discovery.Announcement
+	Discovery         pb_discovery.Client
+	Monitors          map[string]*pb_monitor.Client
+	Ctx               log.Interface
+	Metrics           metrics.Registry
+	AccessToken       string
+	privateKey        *ecdsa.PrivateKey
+	previousKeys      []previousKey
+	keyLock           sync.RWMutex
+	jwtCache          jwtCache
+	tokenCache        tokenCache
+	tlsConfig         *tls.Config
+	clientTLSConfig   *tls.Config
+	tlsLock           sync.RWMutex
+	TokenKeyProvider  tokenkey.Provider
+	tokenKeyLock      sync.RWMutex
+	tokenKeyCache     *tokenKeyCache
+	authServerClients map[string]*http.Client
+	status            int64
+
+	RevocationChecker RevocationChecker
+
+	// OAuthClientFactory builds the OAuth clients that are used by ExchangeAppKeyForToken. It defaults to NewOAuthClient
+	OAuthClientFactory OAuthClientFactory
+
+	// Clock is used to build and validate component tokens. It defaults to the wall clock
+	Clock security.Clock
+
+	// AnnouncementStore stores discovered announcements. It defaults to a per-component in-memory store
+	AnnouncementStore     AnnouncementStore
+	announcementStoreOnce sync.Once
+
+	inflight     inflight
+	refreshStops []func()
+	refreshLock  sync.Mutex
+
+	tokenKeyRefreshOnce sync.Once
+
+	validationLimiter     *failureLimiter
+	validationLimiterOnce sync.Once
+
+	replayCache     *replayCache
+	replayCacheOnce sync.Once
 }
 
 type Interface interface {
@@ -70,19 +110,22 @@ func New(ctx log.Interface, serviceName string, announcedAddress string) (*Compo
 	grpclog.SetLogger(logging.NewGRPCLogger(ctx))
 
 	component := &Component{
-		Config: ConfigFromViper(),
+		Config: serviceConfigFromViper(serviceName, announcedAddress),
 		Ctx:    ctx,
 		Identity: &pb_discovery.Announcement{
 			Id:             viper.GetString("id"),
 			Description:    viper.GetString("description"),
 			ServiceName:    serviceName,
 			ServiceVersion: fmt.Sprintf("%s-%s (%s)", viper.GetString("version"), viper.GetString("gitCommit"), viper.GetString("buildDate")),
-			NetAddress:     announcedAddress,
 			Public:         viper.GetBool("public"),
 		},
 		AccessToken: viper.GetString("auth-token"),
 	}
 
+	if err := component.initNetAddress(); err != nil {
+		return nil, err
+	}
+
 	if err := component.InitAuth(); err != nil {
 		return nil, err
 	}
@@ -115,6 +158,7 @@ func New(ctx log.Interface, serviceName string, announcedAddress string) (*Compo
 				return
 			}
 		})
+		http.Handle("/identity", component.IntrospectionHandler())
 		go http.ListenAndServe(fmt.Sprintf(":%d", healthPort), nil)
 	}
 
@@ -132,3 +176,45 @@ func New(ctx log.Interface, serviceName string, announcedAddress string) (*Compo
 
 	return component, nil
 }
+
+// serviceConfigFromViper returns the ConfigFromViper, with the listen address of the service and the given announced
+// address as defaults. An empty announced address makes the component announce its ListenAddress.
+func serviceConfigFromViper(serviceName string, announcedAddress string) Config {
+	config := ConfigFromViper()
+	if config.ListenAddress == "" {
+		config.ListenAddress = fmt.Sprintf("%s:%d", viper.GetString(serviceName+".server-address"), viper.GetInt(serviceName+".server-port"))
+	}
+	if config.AnnounceAddress == "" {
+		config.AnnounceAddress = announcedAddress
+	}
+	return config
+}
+
+// initNetAddress sets the NetAddress of the Identity to the AnnounceAddress, or to the ListenAddress if no
+// AnnounceAddress is configured
+func (c *Component) initNetAddress() error {
+	address := c.Config.netAddress()
+	if address == "" {
+		return nil
+	}
+	if err := validateNetAddress(address); err != nil {
+		return err
+	}
+	c.Identity.NetAddress = address
+	return nil
+}
+
+// validateNetAddress checks that the address is a valid host:port
+func validateNetAddress(address string) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return errors.NewErrInvalidArgument("Announce address", err.Error())
+	}
+	if host == "" {
+		return errors.NewErrInvalidArgument("Announce address", "host can not be empty")
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return errors.NewErrInvalidArgument("Announce address", fmt.Sprintf("%s is not a valid port", port))
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package component
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/TheThingsNetwork/ttn/api/discovery"
+	"github.com/TheThingsNetwork/ttn/utils/security"
+	"github.com/smartystreets/assertions"
+	"github.com/spf13/viper"
+)
+
+func TestInitNetAddress(t *testing.T) {
+	a := assertions.New(t)
+	c := new(Component)
+	c.Identity = new(discovery.Announcement)
+
+	c.Config.ListenAddress = "0.0.0.0:1902"
+	c.Config.AnnounceAddress = "broker.example.com:443"
+	a.So(c.initNetAddress(), assertions.ShouldBeNil)
+	a.So(c.Identity.NetAddress, assertions.ShouldEqual, "broker.example.com:443")
+
+	c.Config.AnnounceAddress = "[2001:db8::1]:1902"
+	a.So(c.initNetAddress(), assertions.ShouldBeNil)
+	a.So(c.Identity.NetAddress, assertions.ShouldEqual, "[2001:db8::1]:1902")
+
+	c.Config.AnnounceAddress = ""
+	a.So(c.initNetAddress(), assertions.ShouldBeNil)
+	a.So(c.Identity.NetAddress, assertions.ShouldEqual, "0.0.0.0:1902")
+
+	for _, address := range []string{"broker.example.com", ":1902", "broker.example.com:port", "broker.example.com:0", "broker.example.com:70000"} {
+		c.Identity.NetAddress = "unchanged"
+		c.Config.AnnounceAddress = address
+		a.So(c.initNetAddress(), assertions.ShouldNotBeNil)
+		a.So(c.Identity.NetAddress, assertions.ShouldEqual, "unchanged")
+	}
+}
+
+func TestServiceConfigFromViper(t *testing.T) {
+	a := assertions.New(t)
+	viper.Set("discovery.server-address", "0.0.0.0")
+	viper.Set("discovery.server-port", 1900)
+	defer viper.Reset()
+
+	// The Discovery server has no announce address and uses its listen address
+	c := new(Component)
+	c.Identity = new(discovery.Announcement)
+	c.Config = serviceConfigFromViper("discovery", "")
+	a.So(c.Config.ListenAddress, assertions.ShouldEqual, "0.0.0.0:1900")
+	a.So(c.initNetAddress(), assertions.ShouldBeNil)
+	a.So(c.Identity.NetAddress, assertions.ShouldEqual, "0.0.0.0:1900")
+
+	c.Config = serviceConfigFromViper("discovery", "discovery.example.com:1900")
+	a.So(c.initNetAddress(), assertions.ShouldBeNil)
+	a.So(c.Identity.NetAddress, assertions.ShouldEqual, "discovery.example.com:1900")
+}
+
+func TestConfigValidate(t *testing.T) {
+	a := assertions.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "ttn-config")
+	a.So(err, assertions.ShouldBeNil)
+	defer os.RemoveAll(tmpDir)
+	a.So(security.GenerateKeypair(tmpDir), assertions.ShouldBeNil)
+	a.So(security.GenerateCert(tmpDir, "localhost"), assertions.ShouldBeNil)
+
+	valid := Config{
+		ListenAddress: "0.0.0.0:1902",
+		AuthServers:   map[string]string{"ttn": "https://account.thethingsnetwork.org"},
+		KeyDir:        tmpDir,
+		UseTLS:        true,
+	}
+	a.So(valid.Validate(), assertions.ShouldBeNil)
+
+	invalid := Config{
+		AnnounceAddress: "broker.example.com:port",
+		AuthServers: map[string]string{
+			"ttn":    "https://account.thethingsnetwork.org",
+			"broken": "account.example.com",
+		},
+		KeyDir:         tmpDir + "/derp",
+		KeyPermissions: "strict",
+		UseTLS:         true,
+		CAPath:         tmpDir + "/ca.cert",
+		TokenTTL:       time.Second,
+	}
+	err = invalid.Validate()
+	a.So(err, assertions.ShouldNotBeNil)
+	configErrs, ok := err.(ConfigErrors)
+	a.So(ok, assertions.ShouldBeTrue)
+	// Key dir, key permissions, auth server, token TTL, TLS certificate, CA path and announce address
+	a.So(configErrs, assertions.ShouldHaveLength, 7)
+	a.So(err.Error(), assertions.ShouldContainSubstring, "strict")
+	a.So(err.Error(), assertions.ShouldContainSubstring, "broken")
+	a.So(err.Error(), assertions.ShouldContainSubstring, "Announce address")
+
+	a.So(Config{AllowNoAuthServers: true, KeyPEM: []byte("key")}.Validate(), assertions.ShouldBeNil)
+	a.So(Config{}.Validate(), assertions.ShouldHaveLength, 2)
+}
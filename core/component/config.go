@@ -1,19 +1,430 @@
 package component
 
-import "github.com/spf13/viper"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/TheThingsNetwork/ttn/api"
+	"github.com/TheThingsNetwork/ttn/utils/errors"
+	"github.com/spf13/viper"
+)
 
 // Config is the configuration for this component
 type Config struct {
+	// ListenAddress is the host:port that the component listens on
+	ListenAddress string
+	// AnnounceAddress is the host:port that peers use to reach the component. It is announced as the NetAddress
+	// of the component, and defaults to the ListenAddress.
+	AnnounceAddress string
+
 	AuthServers map[string]string
 	KeyDir      string
+	KeyPEM      []byte // Takes precedence over the server.key in KeyDir
+	CertPEM     []byte // Takes precedence over the server.cert in KeyDir
 	UseTLS      bool
+	CAPath      string
+	TokenTTL    time.Duration
+	ClockSkew   time.Duration
+
+	// KeyPassphrase is used to decrypt the private key if it is encrypted
+	KeyPassphrase string
+
+	// KeyPermissions is what happens if the private key in the KeyDir is accessible by others: KeyPermissionsWarn
+	// (default) logs a warning, KeyPermissionsEnforce fails and KeyPermissionsIgnore does nothing
+	KeyPermissions string
+
+	// AuthServerClientCerts are the TLS client certificates that are presented to the auth servers with the same ID,
+	// for auth servers that require mutual TLS. Other auth servers are contacted without client certificate
+	AuthServerClientCerts map[string]ClientCert
+
+	// AuthServerKeyPins are the hex-encoded SHA-256 fingerprints of the token keys of the auth servers with the same
+	// ID, as in the KeyInfo of TokenKeyStatus. Fetched keys that do not match the pin are rejected. The keys of other
+	// auth servers are not pinned
+	AuthServerKeyPins map[string]string
+
+	// RequireAnnouncementCertificate makes ValidateNetworkContext check the certificate of peers with
+	// ValidateAnnouncementCertificate if UseTLS is set
+	RequireAnnouncementCertificate bool
+
+	// RequireAuthenticatedPeers makes ValidateNetworkContext reject peers that do not announce a public key. By default,
+	// such peers are trusted without token
+	RequireAuthenticatedPeers bool
+
+	// VerifyNetAddress makes ValidateNetworkContext reject peers that send a net-address in the metadata that is not
+	// in the NetAddress of their announcement
+	VerifyNetAddress bool
+
+	// SingleUseTokens makes ValidateNetworkContext reject component tokens that were already used, and makes
+	// BuildJWT build a new token for every call. Only enable this if the peers do not reuse their tokens either
+	SingleUseTokens bool
+
+	// TLSInsecureSkipVerify disables verification of server certificates. Only use this for testing
+	TLSInsecureSkipVerify bool
+
+	// AllowNoAuthServers allows the component to run without auth servers
+	AllowNoAuthServers bool
+
+	// PingAuthServers makes AuthHealth check if the auth servers are reachable
+	PingAuthServers bool
+
+	// MetricsRPC makes RegisterMetricsRPC register a gRPC method that returns the metrics to authenticated peers
+	MetricsRPC bool
+
+	// KeyGracePeriod is the time that a previous key stays published after RotateKeyPair
+	KeyGracePeriod time.Duration
+
+	// OAuthTimeout is the timeout for exchanges with the auth server in ExchangeAppKeyForToken
+	OAuthTimeout time.Duration
+	// OAuthTokenRenewMargin is the remaining validity below which a cached OAuth token is exchanged again
+	OAuthTokenRenewMargin time.Duration
+
+	FailedValidationBurst    int
+	FailedValidationInterval time.Duration
+
+	// TokenKeyRefreshInterval is the interval at which InitAuth refreshes the public keys of the auth servers
+	TokenKeyRefreshInterval time.Duration
+
+	// AnnouncementCacheTTL is the time that announcements of peers are cached by ValidateNetworkContext
+	AnnouncementCacheTTL time.Duration
+
+	// AnnounceTimeout is the time after which AnnounceWithRetry gives up
+	AnnounceTimeout time.Duration
+	// AnnounceInterval is the interval at which the component is announced again to survive restarts of the Discovery
+	// server
+	AnnounceInterval time.Duration
+
+	// TrustedIssuers are the component IDs whose tokens are accepted by ValidateNetworkContext. If it is empty,
+	// tokens of all announced components are accepted
+	TrustedIssuers []string
+
+	// AllowedServiceNames are glob patterns (as in path.Match) of the service names that are accepted by
+	// ValidateNetworkContext, for example "router-*". If it is empty, all service names are accepted
+	AllowedServiceNames []string
+
+	// ExpectedAudience is the audience that tokens must be issued for to be accepted by ValidateTTNAuthContext,
+	// typically the ServiceName of the component. If it is empty, the audience is not checked
+	ExpectedAudience string
+
+	// MaxTokenLength is the maximum length of tokens that are accepted by ValidateTTNAuthContext
+	MaxTokenLength int
+
+	// RevocationFailOpen accepts tokens if the RevocationChecker returns an error
+	RevocationFailOpen bool
+}
+
+// ClientCert is a TLS client certificate and its private key, both stored as PEM files
+type ClientCert struct {
+	CertFile string
+	KeyFile  string
+}
+
+// clientCertsFromViper reads a map of client certificates from Viper. The value for each ID has the format
+// "cert-file,key-file"
+func clientCertsFromViper(key string) map[string]ClientCert {
+	values := viper.GetStringMapString(key)
+	if len(values) == 0 {
+		return nil
+	}
+	certs := make(map[string]ClientCert, len(values))
+	for id, value := range values {
+		files := strings.SplitN(value, ",", 2)
+		cert := ClientCert{CertFile: strings.TrimSpace(files[0])}
+		if len(files) == 2 {
+			cert.KeyFile = strings.TrimSpace(files[1])
+		}
+		certs[id] = cert
+	}
+	return certs
 }
 
+// Values of KeyPermissions
+const (
+	KeyPermissionsWarn    = "warn"
+	KeyPermissionsEnforce = "enforce"
+	KeyPermissionsIgnore  = "ignore"
+)
+
+// DefaultTokenTTL is the lifetime of tokens built by the component if no TokenTTL is configured
+var DefaultTokenTTL = 20 * time.Second
+
+// MinTokenTTL is the minimum lifetime that can be configured for tokens built by the component
+var MinTokenTTL = 5 * time.Second
+
+// DefaultKeyGracePeriod is the time that a previous key stays published after a rotation if no KeyGracePeriod is
+// configured. It should be longer than the time peers cache our announcement.
+var DefaultKeyGracePeriod = 10 * time.Minute
+
+// DefaultOAuthTimeout is the timeout for exchanges with the auth server if no OAuthTimeout is configured
+var DefaultOAuthTimeout = 10 * time.Second
+
+// DefaultOAuthTokenRenewMargin is the remaining validity below which a cached OAuth token is exchanged again if no
+// OAuthTokenRenewMargin is configured
+var DefaultOAuthTokenRenewMargin = time.Minute
+
+// DefaultFailedValidationBurst is the number of failed network validations that are allowed for a component before
+// its requests are throttled
+var DefaultFailedValidationBurst = 10
+
+// DefaultFailedValidationInterval is the interval at which a throttled component regains an allowed failed validation
+var DefaultFailedValidationInterval = time.Second
+
+// DefaultTokenKeyRefreshInterval is the interval at which the public keys of the auth servers are refreshed if no
+// TokenKeyRefreshInterval is configured
+var DefaultTokenKeyRefreshInterval = time.Hour
+
+// DefaultAnnouncementCacheTTL is the time that announcements of peers are cached if no AnnouncementCacheTTL is
+// configured
+var DefaultAnnouncementCacheTTL = time.Minute
+
+// DefaultAnnounceTimeout is the time after which AnnounceWithRetry gives up if no AnnounceTimeout is configured
+var DefaultAnnounceTimeout = 2 * time.Minute
+
+// DefaultAnnounceInterval is the interval at which the component is announced again if no AnnounceInterval is
+// configured
+var DefaultAnnounceInterval = 10 * time.Minute
+
 // ConfigFromViper imports configuration from Viper
 func ConfigFromViper() Config {
 	return Config{
+		ListenAddress:   viper.GetString("listen-address"),
+		AnnounceAddress: viper.GetString("announce-address"),
+
 		AuthServers: viper.GetStringMapString("auth-servers"),
 		KeyDir:      viper.GetString("key-dir"),
+		KeyPEM:      []byte(viper.GetString("key-pem")),
+		CertPEM:     []byte(viper.GetString("cert-pem")),
 		UseTLS:      viper.GetBool("tls"),
+		CAPath:      viper.GetString("ca-path"),
+		TokenTTL:    viper.GetDuration("token-ttl"),
+		ClockSkew:   viper.GetDuration("clock-skew"),
+
+		KeyPassphrase:  viper.GetString("key-passphrase"),
+		KeyPermissions: viper.GetString("key-permissions"),
+
+		AuthServerClientCerts: clientCertsFromViper("auth-server-client-certs"),
+		AuthServerKeyPins:     viper.GetStringMapString("auth-server-key-pins"),
+
+		RequireAnnouncementCertificate: viper.GetBool("auth-require-announcement-certificate"),
+		RequireAuthenticatedPeers:      viper.GetBool("auth-require-authenticated-peers"),
+		VerifyNetAddress:               viper.GetBool("auth-verify-net-address"),
+
+		SingleUseTokens: viper.GetBool("auth-single-use-tokens"),
+
+		TLSInsecureSkipVerify: viper.GetBool("tls-insecure-skip-verify"),
+
+		AllowNoAuthServers: viper.GetBool("auth-servers-optional"),
+
+		PingAuthServers: viper.GetBool("auth-health-ping"),
+
+		MetricsRPC: viper.GetBool("metrics-rpc"),
+
+		KeyGracePeriod: viper.GetDuration("key-grace-period"),
+
+		OAuthTimeout:          viper.GetDuration("oauth-timeout"),
+		OAuthTokenRenewMargin: viper.GetDuration("oauth-token-renew-margin"),
+
+		FailedValidationBurst:    viper.GetInt("auth-failure-burst"),
+		FailedValidationInterval: viper.GetDuration("auth-failure-interval"),
+
+		TokenKeyRefreshInterval: viper.GetDuration("auth-token-key-refresh-interval"),
+
+		AnnouncementCacheTTL: viper.GetDuration("auth-announcement-cache-ttl"),
+
+		AnnounceTimeout:  viper.GetDuration("announce-timeout"),
+		AnnounceInterval: viper.GetDuration("announce-interval"),
+
+		TrustedIssuers:      viper.GetStringSlice("auth-trusted-issuers"),
+		AllowedServiceNames: viper.GetStringSlice("auth-allowed-service-names"),
+		ExpectedAudience:    viper.GetString("auth-expected-audience"),
+
+		MaxTokenLength: viper.GetInt("auth-max-token-length"),
+
+		RevocationFailOpen: viper.GetBool("auth-revocation-fail-open"),
+	}
+}
+
+// GetTokenTTL returns the configured TokenTTL, or DefaultTokenTTL if it is not set
+func (c Config) GetTokenTTL() time.Duration {
+	if c.TokenTTL == 0 {
+		return DefaultTokenTTL
+	}
+	return c.TokenTTL
+}
+
+// GetMaxTokenLength returns the configured MaxTokenLength, or api.MaxTokenLength if it is not set
+func (c Config) GetMaxTokenLength() int {
+	if c.MaxTokenLength <= 0 {
+		return api.MaxTokenLength
+	}
+	return c.MaxTokenLength
+}
+
+// GetKeyGracePeriod returns the configured KeyGracePeriod, or DefaultKeyGracePeriod if it is not set
+func (c Config) GetKeyGracePeriod() time.Duration {
+	if c.KeyGracePeriod <= 0 {
+		return DefaultKeyGracePeriod
+	}
+	return c.KeyGracePeriod
+}
+
+// GetOAuthTimeout returns the configured OAuthTimeout, or DefaultOAuthTimeout if it is not set
+func (c Config) GetOAuthTimeout() time.Duration {
+	if c.OAuthTimeout <= 0 {
+		return DefaultOAuthTimeout
+	}
+	return c.OAuthTimeout
+}
+
+// GetOAuthTokenRenewMargin returns the configured OAuthTokenRenewMargin, or DefaultOAuthTokenRenewMargin if it is not set
+func (c Config) GetOAuthTokenRenewMargin() time.Duration {
+	if c.OAuthTokenRenewMargin <= 0 {
+		return DefaultOAuthTokenRenewMargin
+	}
+	return c.OAuthTokenRenewMargin
+}
+
+// GetFailedValidationBurst returns the configured FailedValidationBurst, or DefaultFailedValidationBurst if it is not set
+func (c Config) GetFailedValidationBurst() int {
+	if c.FailedValidationBurst <= 0 {
+		return DefaultFailedValidationBurst
+	}
+	return c.FailedValidationBurst
+}
+
+// GetFailedValidationInterval returns the configured FailedValidationInterval, or DefaultFailedValidationInterval if it is not set
+func (c Config) GetFailedValidationInterval() time.Duration {
+	if c.FailedValidationInterval <= 0 {
+		return DefaultFailedValidationInterval
+	}
+	return c.FailedValidationInterval
+}
+
+// GetAnnouncementCacheTTL returns the configured AnnouncementCacheTTL, or DefaultAnnouncementCacheTTL if it is not set
+func (c Config) GetAnnouncementCacheTTL() time.Duration {
+	if c.AnnouncementCacheTTL <= 0 {
+		return DefaultAnnouncementCacheTTL
+	}
+	return c.AnnouncementCacheTTL
+}
+
+// GetTokenKeyRefreshInterval returns the configured TokenKeyRefreshInterval, or DefaultTokenKeyRefreshInterval if it
+// is not set
+func (c Config) GetTokenKeyRefreshInterval() time.Duration {
+	if c.TokenKeyRefreshInterval <= 0 {
+		return DefaultTokenKeyRefreshInterval
+	}
+	return c.TokenKeyRefreshInterval
+}
+
+// GetAnnounceTimeout returns the configured AnnounceTimeout, or DefaultAnnounceTimeout if it is not set
+func (c Config) GetAnnounceTimeout() time.Duration {
+	if c.AnnounceTimeout <= 0 {
+		return DefaultAnnounceTimeout
+	}
+	return c.AnnounceTimeout
+}
+
+// GetAnnounceInterval returns the configured AnnounceInterval, or DefaultAnnounceInterval if it is not set
+func (c Config) GetAnnounceInterval() time.Duration {
+	if c.AnnounceInterval <= 0 {
+		return DefaultAnnounceInterval
+	}
+	return c.AnnounceInterval
+}
+
+// netAddress returns the AnnounceAddress, or the ListenAddress if no AnnounceAddress is configured
+func (c Config) netAddress() string {
+	if c.AnnounceAddress != "" {
+		return c.AnnounceAddress
+	}
+	return c.ListenAddress
+}
+
+// ConfigErrors contains all problems that were found by Config.Validate
+type ConfigErrors []error
+
+// Error implements the error interface
+func (errs ConfigErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("Invalid configuration: %s", strings.Join(msgs, "; "))
+}
+
+// Validate checks the configuration for problems that would make the Init functions of the component fail. It
+// returns ConfigErrors with every problem that was found, or nil if the configuration is valid.
+func (c Config) Validate() error {
+	var errs ConfigErrors
+
+	if c.KeyDir == "" {
+		if len(c.KeyPEM) == 0 {
+			errs = append(errs, errors.NewErrInvalidArgument("Key dir", "can not be empty if no key PEM is configured"))
+		}
+	} else if info, err := os.Stat(c.KeyDir); err != nil {
+		errs = append(errs, errors.NewErrInvalidArgument("Key dir", err.Error()))
+	} else if !info.IsDir() {
+		errs = append(errs, errors.NewErrInvalidArgument("Key dir", fmt.Sprintf("%s is not a directory", c.KeyDir)))
+	}
+
+	switch c.KeyPermissions {
+	case "", KeyPermissionsWarn, KeyPermissionsEnforce, KeyPermissionsIgnore:
+	default:
+		errs = append(errs, errors.NewErrInvalidArgument("Key permissions", fmt.Sprintf("%s is not one of warn, enforce or ignore", c.KeyPermissions)))
+	}
+
+	if len(c.AuthServers) == 0 && !c.AllowNoAuthServers {
+		errs = append(errs, errors.NewErrInvalidArgument("Auth servers", "at least one auth server must be configured"))
+	}
+	for id, url := range c.AuthServers {
+		if _, err := parseAuthServer(url); err != nil {
+			errs = append(errs, errors.Wrapf(err, "Invalid configuration for auth server %s", id))
+		}
+	}
+	if err := checkDuplicateAuthServers(c.AuthServers); err != nil {
+		errs = append(errs, err)
+	}
+	for id, pin := range c.AuthServerKeyPins {
+		if _, ok := c.AuthServers[id]; !ok {
+			errs = append(errs, errors.NewErrInvalidArgument("Auth server key pin", fmt.Sprintf("auth server %s is not configured", id)))
+		} else if fingerprint, err := hex.DecodeString(pin); err != nil || len(fingerprint) != sha256.Size {
+			errs = append(errs, errors.NewErrInvalidArgument("Auth server key pin", fmt.Sprintf("pin of auth server %s is not a hex-encoded SHA-256 fingerprint", id)))
+		}
+	}
+
+	if c.TokenTTL != 0 && c.TokenTTL < MinTokenTTL {
+		errs = append(errs, errors.NewErrInvalidArgument("Token TTL", fmt.Sprintf("must be at least %s", MinTokenTTL)))
+	}
+
+	if c.UseTLS {
+		if len(c.CertPEM) == 0 {
+			if c.KeyDir == "" {
+				errs = append(errs, errors.NewErrInvalidArgument("TLS certificate", "can not be loaded without key dir"))
+			} else if _, err := os.Stat(filepath.Join(c.KeyDir, "server.cert")); err != nil {
+				errs = append(errs, errors.NewErrInvalidArgument("TLS certificate", err.Error()))
+			}
+		}
+	}
+
+	if c.CAPath != "" {
+		if _, err := os.Stat(c.CAPath); err != nil {
+			errs = append(errs, errors.NewErrInvalidArgument("CA path", err.Error()))
+		}
+	}
+
+	if address := c.netAddress(); address != "" {
+		if err := validateNetAddress(address); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
 	}
+	return nil
 }
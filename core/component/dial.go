@@ -0,0 +1,94 @@
+package component
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"strings"
+
+	"github.com/TheThingsNetwork/ttn/api"
+	pb_discovery "github.com/TheThingsNetwork/ttn/api/discovery"
+	"github.com/TheThingsNetwork/ttn/utils/errors"
+	"golang.org/x/net/context" // See https://github.com/grpc/grpc-go/issues/711"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+)
+
+// DialOptions returns the options to dial the given peer. If the peer announces a certificate, the connection is
+// secured with TLS with the trust settings of the component: the certificate of the peer is verified against the CA
+// pool of the CAPath, or against the announced certificate if no CAPath is configured. Otherwise the connection is
+// insecure. Every RPC on the connection carries the metadata of this component, as set by GetContext.
+func (c *Component) DialOptions(peer *pb_discovery.Announcement) ([]grpc.DialOption, error) {
+	opts := append([]grpc.DialOption{}, api.DialOptions...)
+	tlsConfig, err := c.peerTLSConfig(peer)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+	opts = append(opts, grpc.WithPerRPCCredentials(&componentCredentials{c}))
+	return opts, nil
+}
+
+// Dial dials the first NetAddress of the given peer with the DialOptions for the peer
+func (c *Component) Dial(peer *pb_discovery.Announcement) (*grpc.ClientConn, error) {
+	if peer.NetAddress == "" {
+		return nil, errors.New("Can not dial this component")
+	}
+	opts, err := c.DialOptions(peer)
+	if err != nil {
+		return nil, err
+	}
+	return grpc.Dial(strings.Split(peer.NetAddress, ",")[0], opts...)
+}
+
+// peerTLSConfig returns the TLS configuration to dial the given peer, or nil if the peer does not announce a
+// certificate. It is derived from the client TLS configuration that was loaded by InitAuth.
+func (c *Component) peerTLSConfig(peer *pb_discovery.Announcement) (*tls.Config, error) {
+	if peer == nil || peer.Certificate == "" {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{}
+	if clientConfig := c.getClientTLSConfig(); clientConfig != nil {
+		tlsConfig.RootCAs = clientConfig.RootCAs
+		tlsConfig.InsecureSkipVerify = clientConfig.InsecureSkipVerify
+	}
+	if tlsConfig.RootCAs == nil {
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM([]byte(peer.Certificate)) {
+			return nil, errors.NewErrInvalidArgument("Certificate", "no certificates found")
+		}
+		tlsConfig.RootCAs = roots
+	}
+	return tlsConfig, nil
+}
+
+// componentCredentials implements credentials.PerRPCCredentials with the metadata of the component
+type componentCredentials struct {
+	c *Component
+}
+
+// GetRequestMetadata implements credentials.PerRPCCredentials. Requests with a context that already has a token, for
+// example from GetContext or GetContextForwarding, are left untouched.
+func (creds *componentCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	if md, ok := metadata.FromContext(ctx); ok && len(md[api.MetadataToken]) > 0 {
+		return nil, nil
+	}
+	md, _ := metadata.FromContext(creds.c.GetContext(""))
+	pairs := make(map[string]string, len(md))
+	for k, v := range md {
+		if len(v) > 0 {
+			pairs[k] = v[0]
+		}
+	}
+	return pairs, nil
+}
+
+// RequireTransportSecurity implements credentials.PerRPCCredentials. Like GetContext, the component also sends its
+// token to peers that do not announce a certificate.
+func (creds *componentCredentials) RequireTransportSecurity() bool {
+	return false
+}
@@ -0,0 +1,100 @@
+package component
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/TheThingsNetwork/ttn/api"
+	"github.com/TheThingsNetwork/ttn/api/discovery"
+	"github.com/TheThingsNetwork/ttn/utils/security"
+	"github.com/smartystreets/assertions"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestDialOptions(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	tmpDir := fmt.Sprintf("%s/%d", os.TempDir(), r.Int63())
+	os.Mkdir(tmpDir, 0755)
+	defer os.RemoveAll(tmpDir)
+
+	a := assertions.New(t)
+	c := new(Component)
+	c.Identity = &discovery.Announcement{Id: "test-dial", ServiceName: "router", NetAddress: "localhost:1901"}
+	c.Config.KeyDir = tmpDir
+	security.GenerateKeypair(tmpDir)
+	a.So(c.initKeyPair(), assertions.ShouldBeNil)
+
+	// Peer with certificate
+	peer := buildTestAnnouncement(t, tmpDir)
+	tlsConfig, err := c.peerTLSConfig(peer)
+	a.So(err, assertions.ShouldBeNil)
+	a.So(tlsConfig, assertions.ShouldNotBeNil)
+	a.So(tlsConfig.RootCAs, assertions.ShouldNotBeNil)
+	opts, err := c.DialOptions(peer)
+	a.So(err, assertions.ShouldBeNil)
+	a.So(opts, assertions.ShouldHaveLength, len(api.DialOptions)+2)
+
+	// Peer without certificate
+	tlsConfig, err = c.peerTLSConfig(&discovery.Announcement{Id: "test-insecure"})
+	a.So(err, assertions.ShouldBeNil)
+	a.So(tlsConfig, assertions.ShouldBeNil)
+	opts, err = c.DialOptions(&discovery.Announcement{Id: "test-insecure"})
+	a.So(err, assertions.ShouldBeNil)
+	a.So(opts, assertions.ShouldHaveLength, len(api.DialOptions)+2)
+
+	// Invalid certificate
+	_, err = c.DialOptions(&discovery.Announcement{Certificate: "not a cert"})
+	a.So(err, assertions.ShouldNotBeNil)
+
+	// Trust settings of the component
+	c.Config.CAPath = tmpDir + "/server.cert"
+	c.Config.TLSInsecureSkipVerify = true
+	a.So(c.initClientTLS(), assertions.ShouldBeNil)
+	tlsConfig, err = c.peerTLSConfig(peer)
+	a.So(err, assertions.ShouldBeNil)
+	a.So(tlsConfig.RootCAs, assertions.ShouldEqual, c.getClientTLSConfig().RootCAs)
+	a.So(tlsConfig.InsecureSkipVerify, assertions.ShouldBeTrue)
+
+	// Dial
+	_, err = c.Dial(&discovery.Announcement{Id: "test-unannounced"})
+	a.So(err, assertions.ShouldNotBeNil)
+	conn, err := c.Dial(&discovery.Announcement{Id: "test-insecure", NetAddress: "localhost:1901,127.0.0.1:1901"})
+	a.So(err, assertions.ShouldBeNil)
+	a.So(conn.Close(), assertions.ShouldBeNil)
+}
+
+func TestComponentCredentials(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	tmpDir := fmt.Sprintf("%s/%d", os.TempDir(), r.Int63())
+	os.Mkdir(tmpDir, 0755)
+	defer os.RemoveAll(tmpDir)
+
+	a := assertions.New(t)
+	c := new(Component)
+	c.Identity = &discovery.Announcement{Id: "test-dial", ServiceName: "router", NetAddress: "localhost:1901"}
+	c.Config.KeyDir = tmpDir
+	security.GenerateKeypair(tmpDir)
+	a.So(c.initKeyPair(), assertions.ShouldBeNil)
+
+	creds := &componentCredentials{c}
+	a.So(creds.RequireTransportSecurity(), assertions.ShouldBeFalse)
+
+	md, err := creds.GetRequestMetadata(context.Background())
+	a.So(err, assertions.ShouldBeNil)
+	a.So(md[api.MetadataID], assertions.ShouldEqual, "test-dial")
+	a.So(md[api.MetadataServiceName], assertions.ShouldEqual, "router")
+	a.So(md[api.MetadataNetAddress], assertions.ShouldEqual, "localhost:1901")
+	claims, err := security.ValidateJWT(md[api.MetadataToken], []byte(c.Identity.PublicKey))
+	a.So(err, assertions.ShouldBeNil)
+	a.So(claims.Subject, assertions.ShouldEqual, "test-dial")
+
+	// A token in the context takes precedence
+	ctx := metadata.NewContext(context.Background(), metadata.Pairs(api.MetadataToken, "forwarded"))
+	md, err = creds.GetRequestMetadata(ctx)
+	a.So(err, assertions.ShouldBeNil)
+	a.So(md, assertions.ShouldBeEmpty)
+}
@@ -1,25 +1,115 @@
 package component
 
 import (
+	"fmt"
+	"time"
+
 	pb_discovery "github.com/TheThingsNetwork/ttn/api/discovery"
+	"github.com/TheThingsNetwork/ttn/utils/backoff"
 	"github.com/TheThingsNetwork/ttn/utils/errors"
+	"github.com/apex/log"
+	"golang.org/x/net/context" // See https://github.com/grpc/grpc-go/issues/711"
 )
 
-// Discover is used to discover another component
+// AnnouncementCacheSize is the number of announcements that are cached by the default AnnouncementStore
+var AnnouncementCacheSize = 1000
+
+// AnnounceBackoff is the backoff between the attempts of AnnounceWithRetry
+var AnnounceBackoff = backoff.Config{
+	MaxDelay:  30 * time.Second,
+	BaseDelay: 1 * time.Second,
+	Factor:    1.6,
+	Jitter:    0.2,
+}
+
+// Discover is used to discover another component. It returns an ErrNotFound if the component is not announced,
+// and an ErrUnavailable if the Discovery server could not be reached or failed to handle the request.
+// Discovered announcements are kept in the AnnouncementStore, and are served from there for subsequent calls.
 func (c *Component) Discover(serviceName, id string) (*pb_discovery.Announcement, error) {
-	res, err := c.Discovery.Get(serviceName, id)
+	return c.DiscoverWithContext(context.Background(), serviceName, id)
+}
+
+// DiscoverWithContext is like Discover, but gives up when the context is done. In that case it returns an
+// ErrUnavailable.
+func (c *Component) DiscoverWithContext(ctx context.Context, serviceName, id string) (*pb_discovery.Announcement, error) {
+	store := c.getAnnouncementStore()
+	if res, ok := store.Get(serviceName, id); ok {
+		return res, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrapf(errors.NewErrUnavailable(err.Error()), "Failed to discover %s/%s", serviceName, id)
+	}
+	start := time.Now()
+	res, err := c.discoveryGet(ctx, serviceName, id)
+	c.observeLatency(LatencyDiscover, start)
 	if err != nil {
-		return nil, errors.Wrapf(errors.FromGRPCError(err), "Failed to discover %s/%s", serviceName, id)
+		if ctx.Err() != nil {
+			return nil, errors.Wrapf(errors.NewErrUnavailable(ctx.Err().Error()), "Failed to discover %s/%s", serviceName, id)
+		}
+		err = errors.FromGRPCError(err)
+		if errors.GetErrType(err) == errors.NotFound {
+			return nil, errors.NewErrNotFound(fmt.Sprintf("%s/%s", serviceName, id))
+		}
+		return nil, errors.Wrapf(errors.NewErrUnavailable(err.Error()), "Failed to discover %s/%s", serviceName, id)
 	}
+	store.Put(serviceName, id, res)
 	return res, nil
 }
 
+// discoveryContextGetter is implemented by Discovery clients that can get announcements within the deadline of a
+// context
+type discoveryContextGetter interface {
+	GetWithContext(ctx context.Context, serviceName, id string) (*pb_discovery.Announcement, error)
+}
+
+// discoveryGet gets the announcement from the Discovery client. If the client does not support contexts, the result
+// is abandoned when the context is done.
+func (c *Component) discoveryGet(ctx context.Context, serviceName, id string) (*pb_discovery.Announcement, error) {
+	if getter, ok := c.Discovery.(discoveryContextGetter); ok {
+		return getter.GetWithContext(ctx, serviceName, id)
+	}
+	if ctx.Done() == nil {
+		return c.Discovery.Get(serviceName, id)
+	}
+	type result struct {
+		announcement *pb_discovery.Announcement
+		err          error
+	}
+	results := make(chan result, 1)
+	go func() {
+		announcement, err := c.Discovery.Get(serviceName, id)
+		results <- result{announcement, err}
+	}()
+	select {
+	case res := <-results:
+		return res.announcement, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
 // Announce the component to TTN discovery
 func (c *Component) Announce() error {
 	if c.Identity.Id == "" {
 		return errors.NewErrInvalidArgument("Component ID", "can not be empty")
 	}
-	err := c.Discovery.Announce(c.AccessToken)
+	// The Discovery client calls BuildJWT if it gets no token, which would take the keyLock again
+	token := c.AccessToken
+	if token == "" {
+		var err error
+		if token, err = c.BuildJWT(); err != nil {
+			return errors.Wrap(err, "Could not build token to announce this component")
+		}
+	}
+	var err error
+	if token != "" {
+		// The Discovery client reads the PublicKey of the Identity, which is replaced when the key pair is rotated
+		c.keyLock.RLock()
+		err = c.Discovery.Announce(token)
+		c.keyLock.RUnlock()
+	} else {
+		err = c.Discovery.Announce(token)
+	}
 	if err != nil {
 		return errors.Wrapf(errors.FromGRPCError(err), "Failed to announce this component to TTN discovery: %s", err.Error())
 	}
@@ -27,3 +117,65 @@ func (c *Component) Announce() error {
 
 	return nil
 }
+
+// AnnounceWithRetry announces the component like Announce, but retries with AnnounceBackoff if the announcement
+// fails, until it succeeds or the AnnounceTimeout has passed. Invalid arguments and denied permissions are not retried.
+func (c *Component) AnnounceWithRetry() error {
+	timeout := c.Config.GetAnnounceTimeout()
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		err := c.Announce()
+		if err == nil {
+			return nil
+		}
+		switch errors.GetErrType(err) {
+		case errors.InvalidArgument, errors.PermissionDenied:
+			return err
+		}
+		delay := AnnounceBackoff.Backoff(attempt - 1)
+		if time.Since(start)+delay > timeout {
+			return errors.Wrapf(err, "Gave up after %d attempts", attempt)
+		}
+		c.Ctx.WithError(err).WithFields(log.Fields{
+			"Attempt": attempt,
+			"Retry":   delay,
+		}).Warn("ttn: Failed to announce to TTN discovery, retrying")
+		time.Sleep(delay)
+	}
+}
+
+// StartAnnounceRefresh starts a goroutine that announces the component again at the given interval, so that it is
+// announced again after a restart of the Discovery server. Failed announcements are logged. The returned function
+// stops the goroutine. DrainAuth also stops it.
+func (c *Component) StartAnnounceRefresh(interval time.Duration) (stop func()) {
+	return c.startRefresh(interval, func() {
+		if err := c.Announce(); err != nil {
+			c.Ctx.WithError(err).Warn("ttn: Could not announce to TTN discovery again")
+		}
+	})
+}
+
+// discoveryInvalidator is implemented by Discovery clients that cache announcements themselves
+type discoveryInvalidator interface {
+	Invalidate(serviceName, id string)
+}
+
+// getAnnouncementStore returns the AnnouncementStore of the component. If none was injected, a new in-memory store
+// is created that caches announcements for the configured AnnouncementCacheTTL
+func (c *Component) getAnnouncementStore() AnnouncementStore {
+	c.announcementStoreOnce.Do(func() {
+		if c.AnnouncementStore == nil {
+			c.AnnouncementStore = NewAnnouncementStore(AnnouncementCacheSize, c.Config.GetAnnouncementCacheTTL())
+		}
+	})
+	return c.AnnouncementStore
+}
+
+// invalidateAnnouncement removes the cached announcement for the given service name and id, so that it is
+// discovered again on the next validation
+func (c *Component) invalidateAnnouncement(serviceName, id string) {
+	c.getAnnouncementStore().Remove(serviceName, id)
+	if invalidator, ok := c.Discovery.(discoveryInvalidator); ok {
+		invalidator.Invalidate(serviceName, id)
+	}
+}
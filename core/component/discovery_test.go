@@ -0,0 +1,162 @@
+package component
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/TheThingsNetwork/ttn/api/discovery"
+	errs "github.com/TheThingsNetwork/ttn/utils/errors"
+	"github.com/TheThingsNetwork/ttn/utils/security"
+	. "github.com/TheThingsNetwork/ttn/utils/testing"
+	"github.com/golang/mock/gomock"
+	"github.com/smartystreets/assertions"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+func TestDiscoverWithContext(t *testing.T) {
+	a := assertions.New(t)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	discoveryClient := discovery.NewMockClient(ctrl)
+
+	c := new(Component)
+	c.Discovery = discoveryClient
+	announcement := &discovery.Announcement{Id: "test-slow", ServiceName: "test-service"}
+
+	// Cancelled context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := c.DiscoverWithContext(ctx, "test-service", "test-slow")
+	a.So(err, assertions.ShouldNotBeNil)
+	a.So(errs.GetErrType(err), assertions.ShouldEqual, errs.Unavailable)
+
+	// Slow discovery server
+	discoveryClient.EXPECT().Get("test-service", "test-slow").Do(func(serviceName, id string) {
+		time.Sleep(100 * time.Millisecond)
+	}).Return(announcement, nil)
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = c.DiscoverWithContext(ctx, "test-service", "test-slow")
+	a.So(time.Since(start), assertions.ShouldBeLessThan, 100*time.Millisecond)
+	a.So(err, assertions.ShouldNotBeNil)
+	a.So(errs.GetErrType(err), assertions.ShouldEqual, errs.Unavailable)
+	a.So(grpc.Code(errs.BuildGRPCError(err)), assertions.ShouldEqual, codes.Unavailable)
+	time.Sleep(100 * time.Millisecond)
+
+	// Within the deadline
+	discoveryClient.EXPECT().Get("test-service", "test-slow").Return(announcement, nil)
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	res, err := c.DiscoverWithContext(ctx, "test-service", "test-slow")
+	a.So(err, assertions.ShouldBeNil)
+	a.So(res, assertions.ShouldEqual, announcement)
+}
+
+func TestAnnounceToken(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	tmpDir := fmt.Sprintf("%s/%d", os.TempDir(), r.Int63())
+	os.Mkdir(tmpDir, 0755)
+	defer os.RemoveAll(tmpDir)
+
+	a := assertions.New(t)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	discoveryClient := discovery.NewMockClient(ctrl)
+
+	c := new(Component)
+	c.Ctx = GetLogger(t, "TestAnnounceToken")
+	c.Discovery = discoveryClient
+	c.Identity = &discovery.Announcement{Id: "test-announce", ServiceName: "test-service"}
+	c.Config.KeyDir = tmpDir
+	security.GenerateKeypair(tmpDir)
+	a.So(c.initKeyPair(), assertions.ShouldBeNil)
+
+	// The Discovery client gets a token, so that it does not call BuildJWT while the keyLock is held
+	discoveryClient.EXPECT().Announce(gomock.Any()).Do(func(token string) {
+		claims, err := security.ValidateJWT(token, []byte(c.Identity.PublicKey))
+		a.So(err, assertions.ShouldBeNil)
+		a.So(claims.Subject, assertions.ShouldEqual, "test-announce")
+	}).Return(nil)
+	a.So(c.Announce(), assertions.ShouldBeNil)
+
+	// The AccessToken takes precedence
+	c.AccessToken = "token"
+	discoveryClient.EXPECT().Announce("token").Return(nil)
+	a.So(c.Announce(), assertions.ShouldBeNil)
+}
+
+func TestAnnounceWithRetry(t *testing.T) {
+	a := assertions.New(t)
+
+	defer func(backoff time.Duration) { AnnounceBackoff.BaseDelay = backoff }(AnnounceBackoff.BaseDelay)
+	AnnounceBackoff.BaseDelay = time.Millisecond
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	discoveryClient := discovery.NewMockClient(ctrl)
+
+	c := new(Component)
+	c.Ctx = GetLogger(t, "TestAnnounceWithRetry")
+	c.Discovery = discoveryClient
+	c.Identity = &discovery.Announcement{Id: "test-announce", ServiceName: "test-service"}
+	c.AccessToken = "token"
+
+	// Fails, then succeeds
+	unavailable := grpc.Errorf(codes.Unavailable, "discovery is restarting")
+	gomock.InOrder(
+		discoveryClient.EXPECT().Announce("token").Return(unavailable),
+		discoveryClient.EXPECT().Announce("token").Return(unavailable),
+		discoveryClient.EXPECT().Announce("token").Return(nil),
+	)
+	a.So(c.AnnounceWithRetry(), assertions.ShouldBeNil)
+
+	// Gives up
+	c.Config.AnnounceTimeout = 20 * time.Millisecond
+	discoveryClient.EXPECT().Announce("token").Return(unavailable).MinTimes(2)
+	err := c.AnnounceWithRetry()
+	a.So(err, assertions.ShouldNotBeNil)
+	a.So(errs.GetErrType(err), assertions.ShouldEqual, errs.Unavailable)
+
+	// Does not retry denied permissions
+	discoveryClient.EXPECT().Announce("token").Return(grpc.Errorf(codes.PermissionDenied, "not allowed"))
+	err = c.AnnounceWithRetry()
+	a.So(err, assertions.ShouldNotBeNil)
+	a.So(errs.GetErrType(err), assertions.ShouldEqual, errs.PermissionDenied)
+}
+
+func TestStartAnnounceRefresh(t *testing.T) {
+	a := assertions.New(t)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	discoveryClient := discovery.NewMockClient(ctrl)
+
+	c := new(Component)
+	c.Ctx = GetLogger(t, "TestStartAnnounceRefresh")
+	c.Discovery = discoveryClient
+	c.Identity = &discovery.Announcement{Id: "test-announce", ServiceName: "test-service"}
+
+	announced := make(chan struct{}, 10)
+	discoveryClient.EXPECT().Announce("").Do(func(token string) {
+		announced <- struct{}{}
+	}).Return(grpc.Errorf(codes.Unavailable, "discovery is restarting")).MinTimes(2)
+
+	stop := c.StartAnnounceRefresh(5 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-announced:
+		case <-time.After(time.Second):
+			t.Fatal("Component was not announced again")
+		}
+	}
+	stop()
+	a.So(c.DrainAuth(context.Background()), assertions.ShouldBeNil)
+}
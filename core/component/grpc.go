@@ -1,8 +1,10 @@
 package component
 
 import (
+	"crypto/tls"
 	"time"
 
+	"github.com/TheThingsNetwork/ttn/api"
 	"github.com/TheThingsNetwork/ttn/utils/errors"
 	"github.com/apex/log"
 	"github.com/mwitkow/go-grpc-middleware"
@@ -23,7 +25,7 @@ func (c *Component) ServerOptions() []grpc.ServerOption {
 		var peerID string
 		meta, ok := metadata.FromContext(ctx)
 		if ok {
-			id, ok := meta["id"]
+			id, ok := meta[api.MetadataID]
 			if ok && len(id) > 0 {
 				peerID = id[0]
 			}
@@ -54,7 +56,7 @@ func (c *Component) ServerOptions() []grpc.ServerOption {
 		var peerID string
 		meta, ok := metadata.FromContext(stream.Context())
 		if ok {
-			id, ok := meta["id"]
+			id, ok := meta[api.MetadataID]
 			if ok && len(id) > 0 {
 				peerID = id[0]
 			}
@@ -72,8 +74,9 @@ func (c *Component) ServerOptions() []grpc.ServerOption {
 		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(stream)),
 	}
 
-	if c.tlsConfig != nil {
-		opts = append(opts, grpc.Creds(credentials.NewTLS(c.tlsConfig)))
+	if c.getTLSConfig() != nil {
+		// The certificate is looked up for every handshake, so that it can be replaced with ReloadTLSCertificate
+		opts = append(opts, grpc.Creds(credentials.NewTLS(&tls.Config{GetCertificate: c.getTLSCertificate})))
 	}
 
 	return opts
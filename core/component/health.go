@@ -0,0 +1,125 @@
+package component
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/TheThingsNetwork/ttn/utils/errors"
+	"golang.org/x/net/context" // See https://github.com/grpc/grpc-go/issues/711"
+)
+
+// AuthHealth checks if the auth functionality of the component is usable. It verifies that the private key is loaded,
+// that the TLS certificate is valid (if TLS is used) and that the TokenKeyProvider has a key for at least one auth
+// server. The token keys are only looked up in the cache. If Config.PingAuthServers is set, it also pings every auth
+// server and verifies that at least one of them is reachable.
+func (c *Component) AuthHealth(ctx context.Context) error {
+	c.keyLock.RLock()
+	hasKey := c.privateKey != nil
+	c.keyLock.RUnlock()
+	if !hasKey {
+		return errors.New("No private key loaded")
+	}
+
+	if c.Config.UseTLS {
+		if err := c.checkTLSCertificate(); err != nil {
+			return err
+		}
+	}
+
+	if err := c.checkTokenKeys(); err != nil {
+		return err
+	}
+
+	if c.Config.PingAuthServers {
+		if err := c.pingAuthServers(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Component) checkTLSCertificate() error {
+	tlsConfig := c.getTLSConfig()
+	if tlsConfig == nil || len(tlsConfig.Certificates) == 0 || len(tlsConfig.Certificates[0].Certificate) == 0 {
+		return errors.New("No TLS certificate loaded")
+	}
+	cert, err := x509.ParseCertificate(tlsConfig.Certificates[0].Certificate[0])
+	if err != nil {
+		return errors.Wrap(err, "Invalid TLS certificate")
+	}
+	now := time.Now()
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return errors.New(fmt.Sprintf("TLS certificate is only valid from %s until %s", cert.NotBefore, cert.NotAfter))
+	}
+	return nil
+}
+
+// checkTokenKeys only looks at the keys in the cache of the TokenKeyProvider, so that it never fetches keys from the
+// auth servers
+func (c *Component) checkTokenKeys() error {
+	c.tokenKeyLock.RLock()
+	provider := c.TokenKeyProvider
+	c.tokenKeyLock.RUnlock()
+	if provider == nil {
+		return errors.New("No token key provider configured")
+	}
+	for _, info := range c.TokenKeyStatus() {
+		if info.Cached {
+			return nil
+		}
+	}
+	return errors.New("No token keys available for any auth server")
+}
+
+// pingAuthServers pings every auth server and logs the ones that are not reachable. It only returns an error if none
+// of the auth servers is reachable.
+func (c *Component) pingAuthServers(ctx context.Context) error {
+	ids := make([]string, 0, len(c.Config.AuthServers))
+	for id := range c.Config.AuthServers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var reachable bool
+	var failed []string
+	for _, id := range ids {
+		err := c.pingAuthServer(ctx, id)
+		if err != nil {
+			if c.Ctx != nil {
+				c.Ctx.WithField("AuthServer", id).WithError(err).Warn("ttn: Auth server not reachable")
+			}
+			failed = append(failed, fmt.Sprintf("%s (%s)", id, err))
+			continue
+		}
+		reachable = true
+	}
+	if len(ids) == 0 {
+		return errors.New("No auth servers configured")
+	}
+	if !reachable {
+		return errors.New(fmt.Sprintf("No auth servers reachable: %s", strings.Join(failed, ", ")))
+	}
+	return nil
+}
+
+func (c *Component) pingAuthServer(ctx context.Context, id string) error {
+	srv, err := parseAuthServer(c.Config.AuthServers[id])
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("GET", srv.url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := c.authServerClient(id).Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
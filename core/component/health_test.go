@@ -0,0 +1,118 @@
+package component
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/TheThingsNetwork/go-account-lib/cache"
+	"github.com/TheThingsNetwork/go-account-lib/tokenkey"
+	"github.com/TheThingsNetwork/ttn/api/discovery"
+	"github.com/TheThingsNetwork/ttn/utils/security"
+	"github.com/apex/log"
+	"github.com/smartystreets/assertions"
+	"golang.org/x/net/context"
+)
+
+func TestAuthHealth(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	tmpDir := fmt.Sprintf("%s/%d", os.TempDir(), r.Int63())
+	os.Mkdir(tmpDir, 0755)
+	defer os.RemoveAll(tmpDir)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	a := assertions.New(t)
+	c := new(Component)
+	c.Identity = &discovery.Announcement{Id: "test-health"}
+	c.Config.KeyDir = tmpDir
+	c.Config.AuthServers = map[string]string{"test": srv.URL}
+
+	// No private key
+	a.So(c.AuthHealth(context.Background()), assertions.ShouldNotBeNil)
+
+	security.GenerateKeypair(tmpDir)
+	a.So(c.initKeyPair(), assertions.ShouldBeNil)
+
+	// No TLS certificate
+	c.Config.UseTLS = true
+	a.So(c.AuthHealth(context.Background()), assertions.ShouldNotBeNil)
+
+	security.GenerateCert(tmpDir, "localhost")
+	a.So(c.initTLS(), assertions.ShouldBeNil)
+
+	// No token key provider
+	a.So(c.AuthHealth(context.Background()), assertions.ShouldNotBeNil)
+
+	// No token keys in the cache, even if the provider could get them
+	c.TokenKeyProvider = &testTokenKeyProvider{key: &tokenkey.TokenKey{Algorithm: "RS256"}}
+	a.So(c.AuthHealth(context.Background()), assertions.ShouldNotBeNil)
+	c.tokenKeyCache = newTokenKeyCache(cache.WriteTroughCacheWithFormat(tmpDir, "auth-%s.pub"), nil, nil)
+	a.So(c.AuthHealth(context.Background()), assertions.ShouldNotBeNil)
+
+	data, _ := json.Marshal(&tokenkey.TokenKey{Algorithm: "RS256", Key: "key"})
+	a.So(c.tokenKeyCache.Set("test", data), assertions.ShouldBeNil)
+	a.So(c.AuthHealth(context.Background()), assertions.ShouldBeNil)
+
+	// Reachable auth server
+	c.Config.PingAuthServers = true
+	a.So(c.AuthHealth(context.Background()), assertions.ShouldBeNil)
+
+	// Every auth server is pinged, one reachable auth server is enough
+	c.Config.AuthServers = map[string]string{"test": srv.URL, "unreachable": "http://127.0.0.1:1"}
+	logs := new(testLogHandler)
+	c.Ctx = &log.Logger{Handler: logs, Level: log.DebugLevel}
+	a.So(c.AuthHealth(context.Background()), assertions.ShouldBeNil)
+	a.So(logs.entries, assertions.ShouldHaveLength, 1)
+	a.So(logs.entries[0].Fields["AuthServer"], assertions.ShouldEqual, "unreachable")
+	c.Config.AuthServers = map[string]string{"test": srv.URL}
+
+	// Cancelled context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	a.So(c.AuthHealth(ctx), assertions.ShouldNotBeNil)
+
+	// Unreachable auth server
+	srv.Close()
+	err := c.AuthHealth(context.Background())
+	a.So(err, assertions.ShouldNotBeNil)
+	a.So(err.Error(), assertions.ShouldContainSubstring, "test")
+}
+
+func TestAuthHealthExpiredCertificate(t *testing.T) {
+	a := assertions.New(t)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-2 * time.Hour),
+		NotAfter:     time.Now().Add(-time.Hour),
+	}
+	cert, err := x509.CreateCertificate(crand.Reader, &template, &template, key.Public(), key)
+	a.So(err, assertions.ShouldBeNil)
+
+	c := new(Component)
+	c.privateKey = key
+	c.Config.UseTLS = true
+	c.tlsConfig = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{cert}}}}
+	c.TokenKeyProvider = &testTokenKeyProvider{key: &tokenkey.TokenKey{Algorithm: "RS256"}}
+	c.Config.AuthServers = map[string]string{"test": "https://localhost"}
+
+	err = c.AuthHealth(context.Background())
+	a.So(err, assertions.ShouldNotBeNil)
+	a.So(err.Error(), assertions.ShouldContainSubstring, "TLS certificate")
+}
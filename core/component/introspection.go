@@ -0,0 +1,59 @@
+package component
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/TheThingsNetwork/ttn/utils/security"
+)
+
+// IdentityInfo is the identity of the component as returned by the introspection handler. It does not contain any
+// secrets.
+type IdentityInfo struct {
+	ID          string `json:"id"`
+	ServiceName string `json:"service_name"`
+	NetAddress  string `json:"net_address"`
+	// PublicKeyFingerprint is the hex-encoded SHA-256 hash of the DER-encoded public key of the current keypair
+	PublicKeyFingerprint string `json:"public_key_fingerprint,omitempty"`
+	// AuthServers are the IDs of the configured auth servers
+	AuthServers []string `json:"auth_servers"`
+}
+
+// IdentityInfo returns the announced identity of the component
+func (c *Component) IdentityInfo() IdentityInfo {
+	var info IdentityInfo
+	if c.Identity != nil {
+		info.ID = c.Identity.Id
+		info.ServiceName = c.Identity.ServiceName
+		info.NetAddress = c.Identity.NetAddress
+	}
+
+	c.keyLock.RLock()
+	if c.privateKey != nil {
+		if pubPEM, err := security.PublicPEM(c.privateKey); err == nil {
+			info.PublicKeyFingerprint = keyFingerprint(string(pubPEM))
+		}
+	}
+	c.keyLock.RUnlock()
+
+	info.AuthServers = make([]string, 0, len(c.Config.AuthServers))
+	for id := range c.Config.AuthServers {
+		info.AuthServers = append(info.AuthServers, id)
+	}
+	sort.Strings(info.AuthServers)
+	return info
+}
+
+// IntrospectionHandler returns a read-only http.Handler that responds with the IdentityInfo of the component
+func (c *Component) IntrospectionHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "GET" && req.Method != "HEAD" {
+			w.Header().Set("Allow", "GET, HEAD")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c.IdentityInfo())
+	})
+}
@@ -0,0 +1,79 @@
+package component
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bluele/gcache"
+)
+
+// FailureLimiterSize is the number of keys for which failures are tracked. If more keys fail, the least recently
+// failed keys are forgotten.
+var FailureLimiterSize = 10000
+
+// failureLimiter is a token bucket rate limiter that limits the number of failures per key.
+// Every failure takes a token from the bucket of the key, and tokens are regained at a constant rate.
+type failureLimiter struct {
+	sync.Mutex
+	burst    float64
+	interval time.Duration
+	buckets  gcache.Cache
+}
+
+type failureBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func newFailureLimiter(size int, burst int, interval time.Duration) *failureLimiter {
+	return &failureLimiter{
+		burst:    float64(burst),
+		interval: interval,
+		// Buckets are full again after burst intervals, so they can be forgotten
+		buckets: gcache.New(size).LRU().Expiration(time.Duration(burst) * interval).Build(),
+	}
+}
+
+// refill must be called with the lock held
+func (l *failureLimiter) refill(b *failureBucket, now time.Time) {
+	b.tokens += float64(now.Sub(b.updated)) / float64(l.interval)
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.updated = now
+}
+
+// Throttled returns true if the key has no failures left
+func (l *failureLimiter) Throttled(key string) bool {
+	l.Lock()
+	defer l.Unlock()
+	res, err := l.buckets.Get(key)
+	if err != nil {
+		return false
+	}
+	b := res.(*failureBucket)
+	l.refill(b, time.Now())
+	if b.tokens >= l.burst {
+		l.buckets.Remove(key)
+		return false
+	}
+	return b.tokens < 1
+}
+
+// Fail registers a failure for the key
+func (l *failureLimiter) Fail(key string) {
+	l.Lock()
+	defer l.Unlock()
+	now := time.Now()
+	var b *failureBucket
+	if res, err := l.buckets.Get(key); err == nil {
+		b = res.(*failureBucket)
+	} else {
+		b = &failureBucket{tokens: l.burst, updated: now}
+	}
+	l.refill(b, now)
+	if b.tokens >= 1 {
+		b.tokens--
+	}
+	l.buckets.Set(key, b)
+}
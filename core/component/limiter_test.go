@@ -0,0 +1,42 @@
+package component
+
+import (
+	"testing"
+	"time"
+
+	"github.com/smartystreets/assertions"
+)
+
+func TestFailureLimiter(t *testing.T) {
+	a := assertions.New(t)
+	l := newFailureLimiter(10, 2, 50*time.Millisecond)
+
+	a.So(l.Throttled("a"), assertions.ShouldBeFalse)
+	l.Fail("a")
+	a.So(l.Throttled("a"), assertions.ShouldBeFalse)
+	l.Fail("a")
+	a.So(l.Throttled("a"), assertions.ShouldBeTrue)
+	a.So(l.Throttled("b"), assertions.ShouldBeFalse)
+
+	time.Sleep(60 * time.Millisecond)
+	a.So(l.Throttled("a"), assertions.ShouldBeFalse)
+	l.Fail("a")
+	a.So(l.Throttled("a"), assertions.ShouldBeTrue)
+
+	time.Sleep(110 * time.Millisecond)
+	a.So(l.Throttled("a"), assertions.ShouldBeFalse)
+	_, err := l.buckets.Get("a")
+	a.So(err, assertions.ShouldNotBeNil)
+}
+
+func TestFailureLimiterSize(t *testing.T) {
+	a := assertions.New(t)
+	l := newFailureLimiter(2, 1, time.Hour)
+
+	l.Fail("a")
+	l.Fail("b")
+	l.Fail("c")
+	a.So(l.buckets.Len(), assertions.ShouldEqual, 2)
+	a.So(l.Throttled("a"), assertions.ShouldBeFalse)
+	a.So(l.Throttled("c"), assertions.ShouldBeTrue)
+}
@@ -0,0 +1,201 @@
+package component
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// Outcomes of auth validations that are counted in the metrics registry
+const (
+	AuthSuccess          = "success"
+	AuthInvalidMetadata  = "invalid-metadata"
+	AuthMissingToken     = "missing-token"
+	AuthMissingKey       = "missing-key"
+	AuthTokenTooLong     = "token-too-long"
+	AuthBadSignature     = "bad-signature"
+	AuthBadCertificate   = "bad-certificate"
+	AuthWrongIssuer      = "wrong-issuer"
+	AuthUntrustedIssuer  = "untrusted-issuer"
+	AuthUntrustedService = "untrusted-service"
+	AuthWrongNetAddress  = "wrong-net-address"
+	AuthWrongAudience    = "wrong-audience"
+	AuthDiscoveryError   = "discovery-error"
+	AuthRevoked          = "revoked"
+	AuthReplayed         = "replayed"
+	AuthThrottled        = "throttled"
+)
+
+// Auth operations of which the latency is recorded in the metrics registry
+const (
+	LatencyDiscover = "discover"
+	LatencyJWT      = "jwt"
+	LatencyTTNToken = "ttn-token"
+	LatencyOAuth    = "oauth"
+)
+
+func (c *Component) initMetrics() error {
+	if c.Metrics == nil {
+		c.Metrics = metrics.NewRegistry()
+	}
+	return nil
+}
+
+// knownServiceNames are the service names that are counted in the metrics registry before they are validated
+var knownServiceNames = map[string]bool{
+	"router":        true,
+	"broker":        true,
+	"handler":       true,
+	"networkserver": true,
+	"discovery":     true,
+}
+
+// metricServiceName returns the given service name if it is one of the known service names, or "unknown" otherwise.
+// Service names from the metadata are chosen by the caller, so they must not end up in the metric names unchecked.
+func metricServiceName(serviceName string) string {
+	if knownServiceNames[serviceName] {
+		return serviceName
+	}
+	return "unknown"
+}
+
+// Names of the auth metrics. The metrics are registered with labels in the name (see metricName), that are exported
+// as Prometheus labels by PrometheusText.
+const (
+	authValidationsMetric = "auth_validations_total"
+	authLatencyMetric     = "auth_latency_seconds"
+)
+
+// LatencyBuckets are the upper bounds of the buckets of the auth latency histograms. Auth operations typically take
+// less than a second, so most of the buckets are sub-second.
+var LatencyBuckets = []time.Duration{
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+}
+
+// metricName returns the name of a metric with the given labels, that are given as pairs of label names and values.
+// The name is in the Prometheus notation, for example auth_validations_total{validation="ttn",outcome="success"}.
+func metricName(name string, labels ...string) string {
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+	}
+	if len(pairs) == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s{%s}", name, strings.Join(pairs, ","))
+}
+
+// AuthCounter returns the counter for the given auth validation (network or ttn), caller service name and outcome.
+// Only pass service names that were validated (for example by discovery) or that went through metricServiceName.
+func (c *Component) AuthCounter(validation, serviceName, outcome string) metrics.Counter {
+	if serviceName == "" {
+		serviceName = "unknown"
+	}
+	name := metricName(authValidationsMetric, "validation", validation, "service", serviceName, "outcome", outcome)
+	return metrics.GetOrRegisterCounter(name, c.Metrics)
+}
+
+// AuthLatency returns the latency histogram of the given auth operation. If the component has no metrics registry,
+// the histogram is not registered and discards all observations.
+func (c *Component) AuthLatency(operation string) *LatencyHistogram {
+	if c.Metrics == nil {
+		return NewLatencyHistogram(LatencyBuckets)
+	}
+	name := metricName(authLatencyMetric, "operation", operation)
+	return c.Metrics.GetOrRegister(name, func() metrics.Histogram { return NewLatencyHistogram(LatencyBuckets) }).(*LatencyHistogram)
+}
+
+// observeLatency records the time since start in the latency histogram of the given auth operation
+func (c *Component) observeLatency(operation string, start time.Time) {
+	c.AuthLatency(operation).Observe(time.Since(start))
+}
+
+// LatencyHistogram counts latencies in buckets with fixed upper bounds, so that it can be exported as a Prometheus
+// histogram. It implements metrics.Histogram with values in microseconds so that it can be registered in a
+// metrics.Registry, but it does not keep a sample, so Min, Max, Mean and the percentiles are always zero.
+type LatencyHistogram struct {
+	metrics.NilHistogram
+	buckets []time.Duration
+	counts  []int64
+	count   int64
+	sum     int64
+}
+
+// NewLatencyHistogram returns a new LatencyHistogram with the given (sorted) bucket upper bounds
+func NewLatencyHistogram(buckets []time.Duration) *LatencyHistogram {
+	return &LatencyHistogram{
+		buckets: buckets,
+		counts:  make([]int64, len(buckets)),
+	}
+}
+
+// Observe records the given latency
+func (h *LatencyHistogram) Observe(latency time.Duration) {
+	for i, upper := range h.buckets {
+		if latency <= upper {
+			atomic.AddInt64(&h.counts[i], 1)
+			break
+		}
+	}
+	atomic.AddInt64(&h.sum, int64(latency/time.Microsecond))
+	atomic.AddInt64(&h.count, 1)
+}
+
+// Update records the given latency in microseconds
+func (h *LatencyHistogram) Update(v int64) {
+	h.Observe(time.Duration(v) * time.Microsecond)
+}
+
+// Count returns the number of observations
+func (h *LatencyHistogram) Count() int64 {
+	return atomic.LoadInt64(&h.count)
+}
+
+// Sum returns the sum of the observations in microseconds
+func (h *LatencyHistogram) Sum() int64 {
+	return atomic.LoadInt64(&h.sum)
+}
+
+// Buckets returns the upper bounds of the buckets and the cumulative number of observations in each bucket
+func (h *LatencyHistogram) Buckets() ([]time.Duration, []int64) {
+	counts := make([]int64, len(h.counts))
+	var total int64
+	for i := range h.counts {
+		total += atomic.LoadInt64(&h.counts[i])
+		counts[i] = total
+	}
+	return h.buckets, counts
+}
+
+// Clear resets the histogram
+func (h *LatencyHistogram) Clear() {
+	for i := range h.counts {
+		atomic.StoreInt64(&h.counts[i], 0)
+	}
+	atomic.StoreInt64(&h.sum, 0)
+	atomic.StoreInt64(&h.count, 0)
+}
+
+// Snapshot returns a copy of the histogram
+func (h *LatencyHistogram) Snapshot() metrics.Histogram {
+	snapshot := NewLatencyHistogram(h.buckets)
+	for i := range h.counts {
+		snapshot.counts[i] = atomic.LoadInt64(&h.counts[i])
+	}
+	snapshot.sum, snapshot.count = h.Sum(), h.Count()
+	return snapshot
+}
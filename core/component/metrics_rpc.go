@@ -0,0 +1,144 @@
+package component
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	pb_metrics "github.com/TheThingsNetwork/ttn/api/metrics"
+	"github.com/TheThingsNetwork/ttn/utils/errors"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/rcrowley/go-metrics"
+	"golang.org/x/net/context" // See https://github.com/grpc/grpc-go/issues/711"
+	"google.golang.org/grpc"
+)
+
+// GetMetrics implements the pb_metrics.MetricsServer interface. It returns the metrics of the component to peers that
+// pass ValidateNetworkContext.
+func (c *Component) GetMetrics(ctx context.Context, _ *empty.Empty) (*pb_metrics.MetricsResponse, error) {
+	if _, err := c.ValidateNetworkContext(ctx); err != nil {
+		return nil, errors.BuildGRPCError(err)
+	}
+	return &pb_metrics.MetricsResponse{Text: PrometheusText(c.Metrics)}, nil
+}
+
+// RegisterMetricsRPC registers the metrics service on the gRPC server if Config.MetricsRPC is set
+func (c *Component) RegisterMetricsRPC(s *grpc.Server) {
+	if !c.Config.MetricsRPC {
+		return
+	}
+	pb_metrics.RegisterMetricsServer(s, c)
+}
+
+var prometheusNameRegex = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+
+// prometheusName converts a metric name like auth_validations_total{outcome="success"} or request.size to
+// its Prometheus name (auth_validations_total or request_size) and its labels (outcome="success" or none)
+func prometheusName(name string) (string, string) {
+	var labels string
+	if i := strings.IndexByte(name, '{'); i >= 0 && strings.HasSuffix(name, "}") {
+		name, labels = name[:i], name[i+1:len(name)-1]
+	}
+	return prometheusNameRegex.ReplaceAllString(name, "_"), labels
+}
+
+// prometheusLabels renders the non-empty label lists as {a="b",c="d"}
+func prometheusLabels(labels ...string) string {
+	nonEmpty := make([]string, 0, len(labels))
+	for _, l := range labels {
+		if l != "" {
+			nonEmpty = append(nonEmpty, l)
+		}
+	}
+	if len(nonEmpty) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(nonEmpty, ",") + "}"
+}
+
+// PrometheusText renders the metrics in the registry in the Prometheus text format. Counters, gauges and meters are
+// exported with their current value, LatencyHistograms as histograms in seconds and other histograms and timers as
+// summaries with the 0.5, 0.9 and 0.99 quantiles. Metrics with the same name and different labels are grouped.
+func PrometheusText(registry metrics.Registry) string {
+	if registry == nil {
+		return ""
+	}
+	series := make(map[string]map[string]interface{})
+	registry.Each(func(name string, metric interface{}) {
+		name, labels := prometheusName(name)
+		if series[name] == nil {
+			series[name] = make(map[string]interface{})
+		}
+		series[name][labels] = metric
+	})
+	names := make([]string, 0, len(series))
+	for name := range series {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	quantiles := []float64{0.5, 0.9, 0.99}
+	summary := func(name, labels string, count int64, sum float64, percentiles []float64) {
+		for i, q := range quantiles {
+			fmt.Fprintf(&buf, "%s%s %g\n", name, prometheusLabels(labels, fmt.Sprintf("quantile=\"%g\"", q)), percentiles[i])
+		}
+		fmt.Fprintf(&buf, "%s_sum%s %g\n", name, prometheusLabels(labels), sum)
+		fmt.Fprintf(&buf, "%s_count%s %d\n", name, prometheusLabels(labels), count)
+	}
+	for _, name := range names {
+		labelSets := make([]string, 0, len(series[name]))
+		for labels := range series[name] {
+			labelSets = append(labelSets, labels)
+		}
+		sort.Strings(labelSets)
+		for i, labels := range labelSets {
+			metric := series[name][labels]
+			if i == 0 {
+				var typ string
+				switch metric.(type) {
+				case metrics.Counter, metrics.Meter:
+					typ = "counter"
+				case metrics.Gauge, metrics.GaugeFloat64:
+					typ = "gauge"
+				case *LatencyHistogram:
+					typ = "histogram"
+				case metrics.Histogram, metrics.Timer:
+					typ = "summary"
+				}
+				if typ != "" {
+					fmt.Fprintf(&buf, "# TYPE %s %s\n", name, typ)
+				}
+			}
+			switch metric := metric.(type) {
+			case metrics.Counter:
+				fmt.Fprintf(&buf, "%s%s %d\n", name, prometheusLabels(labels), metric.Count())
+			case metrics.Gauge:
+				fmt.Fprintf(&buf, "%s%s %d\n", name, prometheusLabels(labels), metric.Value())
+			case metrics.GaugeFloat64:
+				fmt.Fprintf(&buf, "%s%s %g\n", name, prometheusLabels(labels), metric.Value())
+			case metrics.Meter:
+				fmt.Fprintf(&buf, "%s%s %d\n", name, prometheusLabels(labels), metric.Count())
+			case *LatencyHistogram:
+				snapshot := metric.Snapshot().(*LatencyHistogram)
+				buckets, counts := snapshot.Buckets()
+				for j, upper := range buckets {
+					fmt.Fprintf(&buf, "%s_bucket%s %d\n", name, prometheusLabels(labels, fmt.Sprintf("le=\"%g\"", upper.Seconds())), counts[j])
+				}
+				fmt.Fprintf(&buf, "%s_bucket%s %d\n", name, prometheusLabels(labels, "le=\"+Inf\""), snapshot.Count())
+				fmt.Fprintf(&buf, "%s_sum%s %g\n", name, prometheusLabels(labels), (time.Duration(snapshot.Sum()) * time.Microsecond).Seconds())
+				fmt.Fprintf(&buf, "%s_count%s %d\n", name, prometheusLabels(labels), snapshot.Count())
+			case metrics.Histogram:
+				snapshot := metric.Snapshot()
+				summary(name, labels, snapshot.Count(), float64(snapshot.Sum()), snapshot.Percentiles(quantiles))
+			case metrics.Timer:
+				snapshot := metric.Snapshot()
+				summary(name, labels, snapshot.Count(), float64(snapshot.Sum()), snapshot.Percentiles(quantiles))
+			}
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,135 @@
+package component
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/TheThingsNetwork/ttn/api/discovery"
+	pb_metrics "github.com/TheThingsNetwork/ttn/api/metrics"
+	"github.com/TheThingsNetwork/ttn/utils/security"
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/rcrowley/go-metrics"
+	"github.com/smartystreets/assertions"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+func TestPrometheusText(t *testing.T) {
+	a := assertions.New(t)
+
+	registry := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter(`auth_validations_total{validation="network",service="router",outcome="success"}`, registry).Inc(3)
+	metrics.GetOrRegisterCounter(`auth_validations_total{validation="ttn",service="handler",outcome="success"}`, registry).Inc(2)
+	metrics.GetOrRegisterGauge("status", registry).Update(1)
+	metrics.GetOrRegisterHistogram("request.size", registry, metrics.NewUniformSample(10)).Update(100)
+	latency := NewLatencyHistogram([]time.Duration{time.Millisecond, 10 * time.Millisecond})
+	latency.Observe(500 * time.Microsecond)
+	latency.Observe(5 * time.Millisecond)
+	latency.Observe(time.Second)
+	registry.Register(`auth_latency_seconds{operation="jwt"}`, latency)
+
+	text := PrometheusText(registry)
+	a.So(text, assertions.ShouldContainSubstring, "# TYPE auth_validations_total counter\n"+
+		"auth_validations_total{validation=\"network\",service=\"router\",outcome=\"success\"} 3\n"+
+		"auth_validations_total{validation=\"ttn\",service=\"handler\",outcome=\"success\"} 2\n")
+	a.So(text, assertions.ShouldContainSubstring, "# TYPE status gauge\nstatus 1\n")
+	a.So(text, assertions.ShouldContainSubstring, "# TYPE request_size summary\n")
+	a.So(text, assertions.ShouldContainSubstring, "request_size{quantile=\"0.99\"} 100\n")
+	a.So(text, assertions.ShouldContainSubstring, "request_size_count 1\n")
+	a.So(text, assertions.ShouldContainSubstring, "# TYPE auth_latency_seconds histogram\n"+
+		"auth_latency_seconds_bucket{operation=\"jwt\",le=\"0.001\"} 1\n"+
+		"auth_latency_seconds_bucket{operation=\"jwt\",le=\"0.01\"} 2\n"+
+		"auth_latency_seconds_bucket{operation=\"jwt\",le=\"+Inf\"} 3\n"+
+		"auth_latency_seconds_sum{operation=\"jwt\"} 1.0055\n"+
+		"auth_latency_seconds_count{operation=\"jwt\"} 3\n")
+
+	a.So(PrometheusText(nil), assertions.ShouldBeEmpty)
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	a := assertions.New(t)
+
+	h := NewLatencyHistogram(LatencyBuckets)
+	h.Observe(3 * time.Millisecond)
+	h.Update(200000)
+	h.Observe(time.Minute)
+	a.So(h.Count(), assertions.ShouldEqual, 3)
+	a.So(h.Sum(), assertions.ShouldEqual, 60203000)
+
+	buckets, counts := h.Buckets()
+	a.So(buckets, assertions.ShouldResemble, LatencyBuckets)
+	a.So(counts[1], assertions.ShouldEqual, 0)
+	a.So(counts[2], assertions.ShouldEqual, 1)
+	a.So(counts[6], assertions.ShouldEqual, 1)
+	a.So(counts[7], assertions.ShouldEqual, 2)
+	a.So(counts[len(counts)-1], assertions.ShouldEqual, 2)
+
+	snapshot := h.Snapshot()
+	h.Clear()
+	a.So(h.Count(), assertions.ShouldEqual, 0)
+	a.So(snapshot.Count(), assertions.ShouldEqual, 3)
+
+	// Registered histograms are kept in the registry
+	registry := metrics.NewRegistry()
+	a.So(registry.Register("latency", h), assertions.ShouldBeNil)
+	a.So(registry.Get("latency"), assertions.ShouldEqual, h)
+}
+
+func TestMetricsRPC(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	tmpDir := fmt.Sprintf("%s/%d", os.TempDir(), r.Int63())
+	os.Mkdir(tmpDir, 0755)
+	defer os.RemoveAll(tmpDir)
+
+	a := assertions.New(t)
+	c := new(Component)
+	c.Identity = &discovery.Announcement{Id: "test-metrics", ServiceName: "router"}
+	c.Config.KeyDir = tmpDir
+	c.Config.MetricsRPC = true
+	security.GenerateKeypair(tmpDir)
+	a.So(c.initKeyPair(), assertions.ShouldBeNil)
+	c.Metrics = metrics.NewRegistry()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	discoveryClient := discovery.NewMockClient(ctrl)
+	c.Discovery = discoveryClient
+	discoveryClient.EXPECT().Get("router", "test-metrics").Return(c.Identity, nil)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	a.So(err, assertions.ShouldBeNil)
+	s := grpc.NewServer()
+	c.RegisterMetricsRPC(s)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure(), grpc.WithBlock(), grpc.WithTimeout(time.Second))
+	a.So(err, assertions.ShouldBeNil)
+	defer conn.Close()
+
+	client := pb_metrics.NewMetricsClient(conn)
+
+	// Without auth
+	_, err = client.GetMetrics(context.Background(), &empty.Empty{})
+	a.So(err, assertions.ShouldNotBeNil)
+	a.So(grpc.Code(err), assertions.ShouldNotEqual, codes.Unimplemented)
+
+	// With auth
+	res, err := client.GetMetrics(c.GetContext(""), &empty.Empty{})
+	a.So(err, assertions.ShouldBeNil)
+	a.So(res.Text, assertions.ShouldContainSubstring, "# TYPE auth_validations_total counter\n")
+	a.So(res.Text, assertions.ShouldContainSubstring, "auth_validations_total{validation=\"network\",service=\"unknown\",outcome=\"invalid-metadata\"} 1\n")
+	a.So(res.Text, assertions.ShouldContainSubstring, "auth_validations_total{validation=\"network\",service=\"router\",outcome=\"success\"} 1\n")
+
+	// Disabled
+	c.Config.MetricsRPC = false
+	disabled := grpc.NewServer()
+	c.RegisterMetricsRPC(disabled)
+	a.So(disabled.GetServiceInfo(), assertions.ShouldBeEmpty)
+}
@@ -0,0 +1,35 @@
+package component
+
+import (
+	"github.com/TheThingsNetwork/go-account-lib/oauth"
+	"golang.org/x/net/context" // See https://github.com/grpc/grpc-go/issues/711"
+	"golang.org/x/oauth2"
+)
+
+// OAuthClient exchanges app keys for tokens with an auth server. The HTTP client to use is in the oauth2.HTTPClient
+// value of the context.
+type OAuthClient interface {
+	PasswordCredentialsToken(ctx context.Context, username, password string) (*oauth2.Token, error)
+}
+
+// OAuthClientFactory returns the OAuthClient for the auth server at the given URL, that authenticates with the given
+// client credentials and requests tokens with the given scopes
+type OAuthClientFactory func(url, clientID, clientSecret string, scopes []string) OAuthClient
+
+// NewOAuthClient is the default OAuthClientFactory, which returns the OAuth client of the go-account-lib
+func NewOAuthClient(url, clientID, clientSecret string, scopes []string) OAuthClient {
+	client := oauth.OAuth(url, &oauth.Client{
+		ID:     clientID,
+		Secret: clientSecret,
+	})
+	client.Scopes = scopes
+	return client
+}
+
+// oauthClient returns an OAuthClient from the configured OAuthClientFactory, or from NewOAuthClient if none is configured
+func (c *Component) oauthClient(url, clientID, clientSecret string, scopes []string) OAuthClient {
+	if c.OAuthClientFactory == nil {
+		return NewOAuthClient(url, clientID, clientSecret, scopes)
+	}
+	return c.OAuthClientFactory(url, clientID, clientSecret, scopes)
+}
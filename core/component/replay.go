@@ -0,0 +1,71 @@
+package component
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// replayCache remembers the IDs of tokens that were used until they expire, so that they can only be used once
+type replayCache struct {
+	sync.Mutex
+	seen    map[string]time.Time
+	expires replayHeap
+}
+
+func newReplayCache() *replayCache {
+	return &replayCache{
+		seen: make(map[string]time.Time),
+	}
+}
+
+// Use marks the token with the given key as used until it expires. It returns false if it was already used at the
+// given time
+func (r *replayCache) Use(key string, expires, now time.Time) bool {
+	r.Lock()
+	defer r.Unlock()
+	r.prune(now)
+	if _, ok := r.seen[key]; ok {
+		return false
+	}
+	r.seen[key] = expires
+	heap.Push(&r.expires, replayEntry{key: key, expires: expires})
+	return true
+}
+
+// prune removes the expired tokens and must be called with the lock held
+func (r *replayCache) prune(now time.Time) {
+	for len(r.expires) > 0 && !now.Before(r.expires[0].expires) {
+		entry := heap.Pop(&r.expires).(replayEntry)
+		if expires, ok := r.seen[entry.key]; ok && expires.Equal(entry.expires) {
+			delete(r.seen, entry.key)
+		}
+	}
+}
+
+// Len returns the number of tokens in the cache
+func (r *replayCache) Len() int {
+	r.Lock()
+	defer r.Unlock()
+	return len(r.seen)
+}
+
+type replayEntry struct {
+	key     string
+	expires time.Time
+}
+
+// replayHeap is a min-heap of replayEntry ordered by expiry
+type replayHeap []replayEntry
+
+func (h replayHeap) Len() int            { return len(h) }
+func (h replayHeap) Less(i, j int) bool  { return h[i].expires.Before(h[j].expires) }
+func (h replayHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *replayHeap) Push(x interface{}) { *h = append(*h, x.(replayEntry)) }
+func (h *replayHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	*h = old[:n-1]
+	return entry
+}
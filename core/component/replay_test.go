@@ -0,0 +1,29 @@
+package component
+
+import (
+	"testing"
+	"time"
+
+	"github.com/smartystreets/assertions"
+)
+
+func TestReplayCache(t *testing.T) {
+	a := assertions.New(t)
+	r := newReplayCache()
+	now := time.Unix(1000, 0)
+
+	a.So(r.Use("a", now.Add(2*time.Second), now), assertions.ShouldBeTrue)
+	a.So(r.Use("b", now.Add(1*time.Second), now), assertions.ShouldBeTrue)
+	a.So(r.Use("c", now.Add(3*time.Second), now), assertions.ShouldBeTrue)
+	a.So(r.Use("a", now.Add(2*time.Second), now), assertions.ShouldBeFalse)
+	a.So(r.Len(), assertions.ShouldEqual, 3)
+
+	// Only the expired tokens are pruned, in order of expiry
+	a.So(r.Use("d", now.Add(5*time.Second), now.Add(2*time.Second)), assertions.ShouldBeTrue)
+	a.So(r.Len(), assertions.ShouldEqual, 2)
+	a.So(r.Use("c", now.Add(3*time.Second), now.Add(2*time.Second)), assertions.ShouldBeFalse)
+
+	// An expired token can be used again
+	a.So(r.Use("a", now.Add(4*time.Second), now.Add(2*time.Second)), assertions.ShouldBeTrue)
+	a.So(r.Len(), assertions.ShouldEqual, 3)
+}
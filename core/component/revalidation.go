@@ -0,0 +1,60 @@
+package component
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context" // See https://github.com/grpc/grpc-go/issues/711"
+)
+
+// RevalidatedContext returns a context that is cancelled as soon as validate fails for the parent context. The
+// validation is repeated every interval until the parent is done or stop is called. Use it on long-lived streams, so
+// that they are torn down when the authorization they were opened with is revoked or expires. The returned stop
+// function releases the resources of the revalidation and returns the error of the failed validation, if any.
+func (c *Component) RevalidatedContext(parent context.Context, interval time.Duration, validate func(context.Context) error) (ctx context.Context, stop func() error) {
+	ctx, cancel := context.WithCancel(parent)
+	var (
+		mu     sync.Mutex
+		err    error
+		ticker = time.NewTicker(interval)
+		done   = make(chan struct{})
+	)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-done:
+				return
+			case <-ticker.C:
+				if validationErr := validate(parent); validationErr != nil {
+					mu.Lock()
+					err = validationErr
+					mu.Unlock()
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return ctx, func() error {
+		once.Do(func() {
+			close(done)
+			cancel()
+		})
+		mu.Lock()
+		defer mu.Unlock()
+		return err
+	}
+}
+
+// RevalidatedTTNAuthContext is like RevalidatedContext, and validates the context with ValidateTTNAuthContext. Streams
+// are torn down when the token expires or when it is revoked according to the RevocationChecker.
+func (c *Component) RevalidatedTTNAuthContext(parent context.Context, interval time.Duration) (context.Context, func() error) {
+	return c.RevalidatedContext(parent, interval, func(ctx context.Context) error {
+		_, err := c.ValidateTTNAuthContext(ctx)
+		return err
+	})
+}
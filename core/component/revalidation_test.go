@@ -0,0 +1,74 @@
+package component
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/TheThingsNetwork/go-account-lib/claims"
+	errs "github.com/TheThingsNetwork/ttn/utils/errors"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/smartystreets/assertions"
+	"golang.org/x/net/context"
+)
+
+func TestRevalidatedContext(t *testing.T) {
+	a := assertions.New(t)
+	c := new(Component)
+
+	var calls int32
+	ctx, stop := c.RevalidatedContext(context.Background(), 5*time.Millisecond, func(context.Context) error {
+		if atomic.AddInt32(&calls, 1) == 3 {
+			return errors.New("Not allowed anymore")
+		}
+		return nil
+	})
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Context was not cancelled")
+	}
+	a.So(atomic.LoadInt32(&calls), assertions.ShouldEqual, 3)
+	a.So(stop(), assertions.ShouldNotBeNil)
+
+	// Stopped before any validation failed
+	ctx, stop = c.RevalidatedContext(context.Background(), time.Millisecond, func(context.Context) error { return nil })
+	a.So(stop(), assertions.ShouldBeNil)
+	a.So(stop(), assertions.ShouldBeNil)
+	a.So(ctx.Err(), assertions.ShouldNotBeNil)
+}
+
+func TestRevalidatedTTNAuthContextRevocation(t *testing.T) {
+	a := assertions.New(t)
+	c := new(Component)
+	token, provider := buildTestTTNToken(t, &claims.Claims{StandardClaims: jwt.StandardClaims{Id: "stream"}})
+	c.TokenKeyProvider = provider
+	revocations := NewRevocationList()
+	c.RevocationChecker = revocations
+
+	// A stream that sends until its context is done
+	ctx, stop := c.RevalidatedTTNAuthContext(ttnAuthContext(token), 5*time.Millisecond)
+	defer stop()
+	closed := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+		t.Fatal("Stream was closed before the token was revoked")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	revocations.Revoke("stream")
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Stream was not closed after the token was revoked")
+	}
+	err := stop()
+	a.So(err, assertions.ShouldNotBeNil)
+	a.So(errs.GetErrType(err), assertions.ShouldEqual, errs.PermissionDenied)
+}
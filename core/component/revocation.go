@@ -0,0 +1,37 @@
+package component
+
+import "sync"
+
+// RevocationChecker checks if a token has been revoked before its expiry
+type RevocationChecker interface {
+	// IsRevoked returns true if the token with the given ID (jti claim) has been revoked
+	IsRevoked(jti string) (bool, error)
+}
+
+// NewRevocationList returns a new in-memory RevocationChecker
+func NewRevocationList() *RevocationList {
+	return &RevocationList{
+		revoked: make(map[string]struct{}),
+	}
+}
+
+// RevocationList is an in-memory RevocationChecker
+type RevocationList struct {
+	sync.RWMutex
+	revoked map[string]struct{}
+}
+
+// Revoke revokes the token with the given ID
+func (l *RevocationList) Revoke(jti string) {
+	l.Lock()
+	defer l.Unlock()
+	l.revoked[jti] = struct{}{}
+}
+
+// IsRevoked implements the RevocationChecker interface
+func (l *RevocationList) IsRevoked(jti string) (bool, error) {
+	l.RLock()
+	defer l.RUnlock()
+	_, ok := l.revoked[jti]
+	return ok, nil
+}
@@ -0,0 +1,90 @@
+package component
+
+import (
+	"sync"
+
+	"github.com/TheThingsNetwork/ttn/utils/errors"
+	"golang.org/x/net/context" // See https://github.com/grpc/grpc-go/issues/711"
+)
+
+// inflight keeps track of the auth validations that are in progress
+type inflight struct {
+	sync.Mutex
+	count    int
+	idle     chan struct{} // closed when count drops to zero
+	draining bool
+}
+
+// begin registers a new validation. It returns false if the component is shutting down
+func (f *inflight) begin() bool {
+	f.Lock()
+	defer f.Unlock()
+	if f.draining {
+		return false
+	}
+	if f.count == 0 {
+		f.idle = make(chan struct{})
+	}
+	f.count++
+	return true
+}
+
+// end marks a validation that was registered with begin as completed
+func (f *inflight) end() {
+	f.Lock()
+	defer f.Unlock()
+	f.count--
+	if f.count == 0 {
+		close(f.idle)
+	}
+}
+
+// drain stops accepting new validations and returns a channel that is closed when all validations have completed
+func (f *inflight) drain() <-chan struct{} {
+	f.Lock()
+	defer f.Unlock()
+	f.draining = true
+	if f.count == 0 {
+		idle := make(chan struct{})
+		close(idle)
+		return idle
+	}
+	return f.idle
+}
+
+// beginValidation registers an auth validation, so that DrainAuth waits for it. The returned function must be called
+// when the validation has completed.
+func (c *Component) beginValidation() (end func(), err error) {
+	if !c.inflight.begin() {
+		return nil, errors.NewErrUnavailable("Component is shutting down")
+	}
+	return c.inflight.end, nil
+}
+
+// DrainAuth stops the refresh loops that were started with StartTokenKeyRefresh and StartAnnounceRefresh, and waits
+// for in-flight auth validations to complete. New validations are rejected with an ErrUnavailable. If the context is
+// done before the component is drained, DrainAuth returns the error of the context. Call it when the component shuts
+// down.
+func (c *Component) DrainAuth(ctx context.Context) error {
+	c.refreshLock.Lock()
+	stops := c.refreshStops
+	c.refreshStops = nil
+	c.refreshLock.Unlock()
+
+	idle := c.inflight.drain()
+	drained := make(chan struct{})
+	go func() {
+		for _, stop := range stops {
+			stop()
+		}
+		<-idle
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "Draining auth did not complete")
+	}
+}
@@ -0,0 +1,66 @@
+package component
+
+import (
+	"testing"
+	"time"
+
+	errs "github.com/TheThingsNetwork/ttn/utils/errors"
+	. "github.com/TheThingsNetwork/ttn/utils/testing"
+	"github.com/smartystreets/assertions"
+	"golang.org/x/net/context"
+)
+
+func TestDrainAuthStopsRefresh(t *testing.T) {
+	a := assertions.New(t)
+	c := new(Component)
+	c.Ctx = GetLogger(t, "TestDrainAuthStopsRefresh")
+	provider := &testTokenKeyProvider{}
+	c.TokenKeyProvider = provider
+
+	c.StartTokenKeyRefresh(10 * time.Millisecond)
+	c.StartTokenKeyRefresh(10 * time.Millisecond)
+	time.Sleep(25 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	a.So(c.DrainAuth(ctx), assertions.ShouldBeNil)
+
+	c.tokenKeyLock.RLock()
+	updates := provider.updates
+	c.tokenKeyLock.RUnlock()
+	a.So(updates, assertions.ShouldBeGreaterThan, 0)
+
+	time.Sleep(30 * time.Millisecond)
+	c.tokenKeyLock.RLock()
+	a.So(provider.updates, assertions.ShouldEqual, updates)
+	c.tokenKeyLock.RUnlock()
+}
+
+func TestDrainAuthDrainsValidations(t *testing.T) {
+	a := assertions.New(t)
+	c := new(Component)
+
+	end, err := c.beginValidation()
+	a.So(err, assertions.ShouldBeNil)
+
+	// The deadline is exceeded while the validation is in flight
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	a.So(c.DrainAuth(ctx), assertions.ShouldNotBeNil)
+
+	// New validations are rejected
+	_, err = c.ValidateNetworkContext(context.Background())
+	a.So(err, assertions.ShouldNotBeNil)
+	a.So(errs.GetErrType(err), assertions.ShouldEqual, errs.Unavailable)
+	_, err = c.ValidateTTNAuthContext(context.Background())
+	a.So(errs.GetErrType(err), assertions.ShouldEqual, errs.Unavailable)
+
+	// DrainAuth completes when the validation completes
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		end()
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	a.So(c.DrainAuth(ctx), assertions.ShouldBeNil)
+}
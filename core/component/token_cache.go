@@ -0,0 +1,49 @@
+package component
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// tokenCache caches OAuth tokens that were obtained with ExchangeAppKeyForToken
+type tokenCache struct {
+	sync.Mutex
+	tokens map[string]*oauth2.Token
+}
+
+// get returns the cached token for the given key if it is valid for at least the given margin
+func (c *tokenCache) get(key string, margin time.Duration) *oauth2.Token {
+	c.Lock()
+	defer c.Unlock()
+	token, ok := c.tokens[key]
+	if !ok {
+		return nil
+	}
+	if token.Expiry.IsZero() || time.Now().Add(margin).After(token.Expiry) {
+		delete(c.tokens, key)
+		return nil
+	}
+	return token
+}
+
+// set caches the given token for the given key. Tokens without an expiry are not cached
+func (c *tokenCache) set(key string, token *oauth2.Token) {
+	if token.Expiry.IsZero() {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.tokens == nil {
+		c.tokens = make(map[string]*oauth2.Token)
+	}
+	c.tokens[key] = token
+}
+
+// clear removes all cached tokens
+func (c *tokenCache) clear() {
+	c.Lock()
+	defer c.Unlock()
+	c.tokens = nil
+}
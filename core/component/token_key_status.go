@@ -0,0 +1,117 @@
+package component
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"sync"
+	"time"
+
+	"github.com/TheThingsNetwork/go-account-lib/cache"
+	"github.com/TheThingsNetwork/go-account-lib/tokenkey"
+	"github.com/apex/log"
+)
+
+// KeyInfo is the status of the token key of an auth server, as returned by TokenKeyStatus
+type KeyInfo struct {
+	// Cached is true if a key for the auth server is in the cache
+	Cached bool
+	// Fingerprint is the hex-encoded SHA-256 hash of the DER-encoded public key
+	Fingerprint string
+	// LastRefresh is the time at which the key was last written to the cache by this process. It is zero if the key
+	// was cached by a previous run.
+	LastRefresh time.Time
+}
+
+// tokenKeyCache wraps the cache of the TokenKeyProvider to record when keys were refreshed. Keys that do not match
+// their pin are never written to the cache, so that they can not replace a good key that is cached already.
+type tokenKeyCache struct {
+	cache.Cache
+	sync.Mutex
+	refreshed map[string]time.Time
+	pins      map[string]string
+	ctx       log.Interface
+}
+
+func newTokenKeyCache(c cache.Cache, pins map[string]string, ctx log.Interface) *tokenKeyCache {
+	return &tokenKeyCache{
+		Cache:     c,
+		refreshed: make(map[string]time.Time),
+		pins:      pins,
+		ctx:       ctx,
+	}
+}
+
+// Set implements the cache.Cache interface
+func (c *tokenKeyCache) Set(key string, data []byte) error {
+	if _, pinned := c.pins[key]; pinned {
+		var tokenKey tokenkey.TokenKey
+		if err := json.Unmarshal(data, &tokenKey); err != nil {
+			return err
+		}
+		if err := checkKeyPin(c.pins, key, &tokenKey); err != nil {
+			if c.ctx != nil {
+				c.ctx.WithFields(log.Fields{
+					"AuthServer":  key,
+					"Fingerprint": keyFingerprint(tokenKey.Key),
+					"Pin":         c.pins[key],
+				}).Warn("ttn: Token key of auth server does not match the pinned fingerprint, the auth server may be compromised")
+			}
+			return err
+		}
+	}
+	if err := c.Cache.Set(key, data); err != nil {
+		return err
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.refreshed[key] = time.Now()
+	return nil
+}
+
+// status returns the KeyInfo of the cached key of the given auth server
+func (c *tokenKeyCache) status(server string) (info KeyInfo) {
+	c.Lock()
+	info.LastRefresh = c.refreshed[server]
+	c.Unlock()
+
+	data, err := c.Cache.Get(server)
+	if err != nil || data == nil {
+		return
+	}
+	var key tokenkey.TokenKey
+	if err := json.Unmarshal(data, &key); err != nil || key.Key == "" {
+		return
+	}
+	info.Cached = true
+	info.Fingerprint = keyFingerprint(key.Key)
+	return
+}
+
+func keyFingerprint(key string) string {
+	der := []byte(key)
+	if block, _ := pem.Decode(der); block != nil {
+		der = block.Bytes
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// TokenKeyStatus returns the status of the cached token key for each configured auth server. It only reads from the
+// cache, and does not fetch keys that are missing.
+func (c *Component) TokenKeyStatus() map[string]KeyInfo {
+	c.tokenKeyLock.RLock()
+	keyCache := c.tokenKeyCache
+	c.tokenKeyLock.RUnlock()
+
+	status := make(map[string]KeyInfo, len(c.Config.AuthServers))
+	for id := range c.Config.AuthServers {
+		if keyCache == nil {
+			status[id] = KeyInfo{}
+			continue
+		}
+		status[id] = keyCache.status(id)
+	}
+	return status
+}
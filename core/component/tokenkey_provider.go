@@ -0,0 +1,205 @@
+package component
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/TheThingsNetwork/go-account-lib/cache"
+	"github.com/TheThingsNetwork/go-account-lib/tokenkey"
+	"github.com/TheThingsNetwork/ttn/utils/errors"
+)
+
+// stagedTokenKeyProvider is a tokenkey.Provider that can fetch the keys of the auth servers without replacing the keys
+// that are in use. UpdateTokenKey uses it to only hold the tokenKeyLock while the fetched keys are applied.
+type stagedTokenKeyProvider interface {
+	tokenkey.Provider
+	// fetchKeys fetches the keys and returns a function that replaces the keys in use by the fetched keys. The
+	// function returns the first error that occurred while fetching or applying the keys.
+	fetchKeys() (apply func() error)
+}
+
+// clientTokenKeyProvider is a tokenkey.Provider that fetches the token keys with a separate http.Client per auth
+// server, or http.DefaultClient for auth servers that do not require TLS client certificates.
+type clientTokenKeyProvider struct {
+	urls    map[string]string
+	clients map[string]*http.Client
+	cache   cache.Cache
+}
+
+// String implements the tokenkey.Provider interface
+func (p *clientTokenKeyProvider) String() string {
+	return fmt.Sprintf("%v", p.urls)
+}
+
+// Get implements the tokenkey.Provider interface
+func (p *clientTokenKeyProvider) Get(server string, renew bool) (*tokenkey.TokenKey, error) {
+	if !renew && p.cache != nil {
+		if data, err := p.cache.Get(server); err == nil && data != nil {
+			var key tokenkey.TokenKey
+			if err := json.Unmarshal(data, &key); err == nil {
+				return &key, nil
+			}
+		}
+	}
+	data, err := p.fetch(server)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.store(server, data); err != nil {
+		return nil, err
+	}
+	var key tokenkey.TokenKey
+	json.Unmarshal(data, &key)
+	return &key, nil
+}
+
+// Update implements the tokenkey.Provider interface
+func (p *clientTokenKeyProvider) Update() error {
+	return p.fetchKeys()()
+}
+
+// fetchKeys implements the stagedTokenKeyProvider interface
+func (p *clientTokenKeyProvider) fetchKeys() func() error {
+	servers := make([]string, 0, len(p.urls))
+	for server := range p.urls {
+		servers = append(servers, server)
+	}
+	sort.Strings(servers)
+	fetched := make(map[string][]byte, len(servers))
+	var err error
+	for _, server := range servers {
+		data, fetchErr := p.fetch(server)
+		if fetchErr != nil {
+			if err == nil {
+				err = fetchErr
+			}
+			continue
+		}
+		fetched[server] = data
+	}
+	return func() error {
+		for _, server := range servers {
+			data, ok := fetched[server]
+			if !ok {
+				continue
+			}
+			if storeErr := p.store(server, data); storeErr != nil && err == nil {
+				err = storeErr
+			}
+		}
+		return err
+	}
+}
+
+// fetch gets the token key of the auth server and returns it as JSON
+func (p *clientTokenKeyProvider) fetch(server string) ([]byte, error) {
+	url, ok := p.urls[server]
+	if !ok {
+		return nil, fmt.Errorf("Auth server %s not registered", server)
+	}
+	client, ok := p.clients[server]
+	if !ok {
+		client = http.DefaultClient
+	}
+	res, err := client.Get(url + "/key")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Auth server %s returned status %d for token key", server, res.StatusCode)
+	}
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	var key tokenkey.TokenKey
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// store writes the token key of the auth server to the cache
+func (p *clientTokenKeyProvider) store(server string, data []byte) error {
+	if p.cache == nil {
+		return nil
+	}
+	// Keys that do not match their pin are refused by the cache, other cache errors do not fail the fetch
+	if err := p.cache.Set(server, data); errors.GetErrType(err) == errors.PermissionDenied {
+		return err
+	}
+	return nil
+}
+
+// pinnedTokenKeyProvider is a tokenkey.Provider that rejects the keys of auth servers that do not match the fingerprint
+// that is pinned in the AuthServerKeyPins. The keys of other auth servers are passed through. The tokenKeyCache of the
+// provider already refuses to persist such keys, this also rejects keys that are returned without being cached.
+type pinnedTokenKeyProvider struct {
+	tokenkey.Provider
+	pins map[string]string
+}
+
+// Get implements the tokenkey.Provider interface
+func (p *pinnedTokenKeyProvider) Get(server string, renew bool) (*tokenkey.TokenKey, error) {
+	key, err := p.Provider.Get(server, renew)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKeyPin(p.pins, server, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Update implements the tokenkey.Provider interface
+func (p *pinnedTokenKeyProvider) Update() error {
+	return p.checkPins(p.Provider.Update())
+}
+
+// fetchKeys implements the stagedTokenKeyProvider interface. If the wrapped provider can not fetch its keys in advance,
+// they are updated when the returned function is called. Otherwise the pins are not checked again after the keys are
+// applied, as that could fetch keys while the tokenKeyLock is held; the tokenKeyCache already refuses keys that do not
+// match their pin, and Get checks every key that is used.
+func (p *pinnedTokenKeyProvider) fetchKeys() func() error {
+	staged, ok := p.Provider.(stagedTokenKeyProvider)
+	if !ok {
+		return p.Update
+	}
+	return staged.fetchKeys()
+}
+
+// checkPins returns err, or otherwise an error if the current key of a pinned auth server does not match its pin
+func (p *pinnedTokenKeyProvider) checkPins(err error) error {
+	servers := make([]string, 0, len(p.pins))
+	for server := range p.pins {
+		servers = append(servers, server)
+	}
+	sort.Strings(servers)
+	for _, server := range servers {
+		key, getErr := p.Provider.Get(server, false)
+		if getErr != nil {
+			continue
+		}
+		if pinErr := checkKeyPin(p.pins, server, key); pinErr != nil && err == nil {
+			err = pinErr
+		}
+	}
+	return err
+}
+
+// checkKeyPin returns an error if the pins contain a fingerprint for the given auth server that does not match the key
+func checkKeyPin(pins map[string]string, server string, key *tokenkey.TokenKey) error {
+	pin, ok := pins[server]
+	if !ok {
+		return nil
+	}
+	if !strings.EqualFold(keyFingerprint(key.Key), pin) {
+		return errors.NewErrPermissionDenied(fmt.Sprintf("Token key of auth server %s does not match the pinned fingerprint", server))
+	}
+	return nil
+}
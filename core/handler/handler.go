@@ -103,10 +103,11 @@ func (h *handler) Init(c *component.Component) error {
 		return err
 	}
 
-	err = h.Announce()
+	err = h.AnnounceWithRetry()
 	if err != nil {
 		return err
 	}
+	h.StartAnnounceRefresh(c.Config.GetAnnounceInterval())
 
 	if h.mqttEnabled {
 		var brokers []string
@@ -150,7 +151,7 @@ func (h *handler) associateBroker() error {
 	if err != nil {
 		return err
 	}
-	conn, err := broker.Dial()
+	conn, err := h.Dial(broker)
 	if err != nil {
 		return err
 	}
@@ -75,10 +75,11 @@ func (r *router) Init(c *component.Component) error {
 	if err != nil {
 		return err
 	}
-	err = r.Component.Announce()
+	err = r.Component.AnnounceWithRetry()
 	if err != nil {
 		return err
 	}
+	r.Component.StartAnnounceRefresh(c.Config.GetAnnounceInterval())
 	r.Discovery.GetAll("broker") // Update cache
 
 	go func() {
@@ -138,7 +139,7 @@ func (r *router) getBroker(brokerAnnouncement *pb_discovery.Announcement) (*brok
 	if _, ok := r.brokers[brokerAnnouncement.Id]; !ok {
 
 		// Connect to the server
-		conn, err := brokerAnnouncement.Dial()
+		conn, err := r.Dial(brokerAnnouncement)
 		if err != nil {
 			return nil, err
 		}
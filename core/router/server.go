@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"io"
 
-	"github.com/TheThingsNetwork/go-account-lib/claims"
 	"github.com/TheThingsNetwork/ttn/api"
 	pb "github.com/TheThingsNetwork/ttn/api/router"
 	"github.com/TheThingsNetwork/ttn/core/router/gateway"
@@ -39,10 +38,10 @@ func (r *routerRPC) gatewayFromContext(ctx context.Context) (gtw *gateway.Gatewa
 		if token == "" {
 			return nil, errors.NewErrPermissionDenied("No gateway token supplied")
 		}
-		if r.router.TokenKeyProvider == nil {
-			return nil, errors.NewErrInternal("No token provider configured")
+		claims, err := r.router.ClaimsFromToken(token)
+		if errors.GetErrType(err) == errors.Internal {
+			return nil, err
 		}
-		claims, err := claims.FromToken(r.router.TokenKeyProvider, token)
 		if err != nil {
 			return nil, errors.NewErrPermissionDenied(fmt.Sprintf("Gateway token invalid: %s", err.Error()))
 		}
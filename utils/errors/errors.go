@@ -20,6 +20,7 @@ const (
 	NotFound         ErrType = "not found"
 	OutOfRange       ErrType = "out of range"
 	PermissionDenied ErrType = "permission denied"
+	Unavailable      ErrType = "unavailable"
 	Unknown          ErrType = "unknown"
 )
 
@@ -36,6 +37,8 @@ func GetErrType(err error) ErrType {
 		return NotFound
 	case *ErrPermissionDenied:
 		return PermissionDenied
+	case *ErrUnavailable:
+		return Unavailable
 	}
 	return Unknown
 }
@@ -59,6 +62,8 @@ func BuildGRPCError(err error) error {
 		code = codes.NotFound
 	case *ErrPermissionDenied:
 		code = codes.PermissionDenied
+	case *ErrUnavailable:
+		code = codes.Unavailable
 	}
 	return grpcErrf(code, err.Error())
 }
@@ -84,6 +89,8 @@ func FromGRPCError(err error) error {
 		return NewErrNotFound(strings.TrimSuffix(desc, " not found"))
 	case codes.PermissionDenied:
 		return NewErrPermissionDenied(strings.TrimPrefix(desc, "permission denied: "))
+	case codes.Unavailable:
+		return NewErrUnavailable(strings.TrimPrefix(desc, "unavailable: "))
 	case codes.Unknown: // This also includes all non-gRPC errors
 		return errs.New(err.Error())
 	}
@@ -166,6 +173,21 @@ func (err ErrPermissionDenied) Error() string {
 	return fmt.Sprintf("permission denied: %s", err.reason)
 }
 
+// NewErrUnavailable returns a new ErrUnavailable with the given reason
+func NewErrUnavailable(reason string) error {
+	return &ErrUnavailable{reason: reason}
+}
+
+// ErrUnavailable indicates that a service that is needed to handle the request is unavailable
+type ErrUnavailable struct {
+	reason string
+}
+
+// Error implements the error interface
+func (err ErrUnavailable) Error() string {
+	return fmt.Sprintf("unavailable: %s", err.reason)
+}
+
 // Wrapf returns an error annotating err with the format specifier.
 // If err is nil, Wrapf returns nil.
 func Wrapf(err error, format string, args ...interface{}) error {
@@ -0,0 +1,15 @@
+package security
+
+import "time"
+
+// Clock tells the current time. It can be replaced in tests to build and validate tokens at a given time.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// RealClock is the Clock that reads the wall clock
+var RealClock Clock = realClock{}
@@ -2,24 +2,54 @@ package security
 
 import (
 	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"math"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 )
 
-// BuildJWT builds a JSON Web Token for the given subject and ttl, and signs it with the given private key
+// BuildJWT builds a JSON Web Token for the given subject and ttl, and signs it with the given private key. Every token
+// gets a unique ID (jti claim).
 func BuildJWT(subject string, ttl time.Duration, privateKey []byte) (token string, err error) {
+	return BuildJWTWithKeyID(subject, ttl, privateKey, "")
+}
+
+// BuildJWTWithKeyID builds a JSON Web Token like BuildJWT, and sets the kid header to the given key ID, so that the
+// token can be validated against the right key if the issuer publishes multiple keys. Use KeyID to get the ID of a
+// public key. If keyID is empty, no kid header is set.
+func BuildJWTWithKeyID(subject string, ttl time.Duration, privateKey []byte, keyID string) (token string, err error) {
+	return BuildJWTWithClock(RealClock, subject, ttl, privateKey, keyID)
+}
+
+// BuildJWTWithClock builds a JSON Web Token like BuildJWTWithKeyID, but takes the current time from the given clock
+func BuildJWTWithClock(clock Clock, subject string, ttl time.Duration, privateKey []byte, keyID string) (token string, err error) {
+	id, err := randomTokenID()
+	if err != nil {
+		return "", err
+	}
+	now := clock.Now()
 	claims := jwt.StandardClaims{
+		Id:        id,
 		Issuer:    subject,
 		Subject:   subject,
-		IssuedAt:  time.Now().Add(-20 * time.Second).Unix(),
-		NotBefore: time.Now().Add(-20 * time.Second).Unix(),
+		IssuedAt:  now.Add(-20 * time.Second).Unix(),
+		NotBefore: now.Add(-20 * time.Second).Unix(),
 	}
 	if ttl > 0 {
-		claims.ExpiresAt = time.Now().Add(ttl).Unix()
+		claims.ExpiresAt = now.Add(ttl).Unix()
 	}
 	tokenBuilder := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	if keyID != "" {
+		tokenBuilder.Header["kid"] = keyID
+	}
 	var key *ecdsa.PrivateKey
 	key, err = jwt.ParseECPrivateKeyFromPEM(privateKey)
 	if err != nil {
@@ -32,21 +62,187 @@ func BuildJWT(subject string, ttl time.Duration, privateKey []byte) (token strin
 	return
 }
 
-// ValidateJWT validates a JSON Web Token with the given public key
+// randomTokenID returns a random ID for the jti claim
+func randomTokenID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(id), nil
+}
+
+// ValidJWTMethods are the signing methods that are accepted by ValidateJWT
+var ValidJWTMethods = []string{
+	jwt.SigningMethodES256.Alg(),
+	jwt.SigningMethodRS256.Alg(),
+}
+
+// ValidateJWT validates a JSON Web Token with the given public key. The public key is parsed according to the
+// signing method in the token header, so that an ES256 token requires an EC key and an RS256 token an RSA key.
 func ValidateJWT(token string, publicKey []byte) (*jwt.StandardClaims, error) {
-	claims := &jwt.StandardClaims{}
-	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodECDSA); !ok {
-			return nil, fmt.Errorf("Unexpected JWT signing method: %v", token.Header["alg"])
-		}
-		key, err := jwt.ParseECPublicKeyFromPEM(publicKey)
+	return ValidateJWTWithLeeway(token, publicKey, 0)
+}
+
+// ValidateJWTWithLeeway validates a JSON Web Token like ValidateJWT, but allows the exp, nbf and iat claims to be off
+// by the given leeway to account for clock skew between the issuer and this machine.
+//
+// If publicKey contains multiple PEM blocks (for example during a key rotation), the token is validated with the key
+// that matches the kid header of the token, and tokens with an unknown kid are rejected. Tokens without kid header
+// are accepted if they validate with any of the keys.
+func ValidateJWTWithLeeway(token string, publicKey []byte, leeway time.Duration) (claims *jwt.StandardClaims, err error) {
+	return ValidateJWTWithClock(RealClock, token, publicKey, leeway)
+}
+
+// ValidateJWTWithClock validates a JSON Web Token like ValidateJWTWithLeeway, but checks the exp, nbf and iat claims
+// against the time of the given clock. A token is still valid at the exact second of its exp claim.
+func ValidateJWTWithClock(clock Clock, token string, publicKey []byte, leeway time.Duration) (claims *jwt.StandardClaims, err error) {
+	keys := splitPEM(publicKey)
+	if keyID := tokenKeyID(token); keyID != "" {
+		keys, err = selectKey(keys, keyID)
 		if err != nil {
 			return nil, err
 		}
-		return key, nil
+	}
+	for _, key := range keys {
+		claims, err = validateJWT(token, key, leeway, clock)
+		if err == nil {
+			return claims, nil
+		}
+	}
+	return nil, err
+}
+
+// splitPEM splits data into its individual PEM blocks. If data contains less than two PEM blocks, it is returned as-is
+func splitPEM(data []byte) [][]byte {
+	var blocks [][]byte
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		blocks = append(blocks, pem.EncodeToMemory(block))
+	}
+	if len(blocks) < 2 {
+		return [][]byte{data}
+	}
+	return blocks
+}
+
+// KeyID returns the ID of the given PEM-encoded public key, which is the unpadded base64url encoding of the SHA-256
+// hash of the DER-encoded key
+func KeyID(publicKey []byte) (string, error) {
+	block, _ := pem.Decode(publicKey)
+	if block == nil {
+		return "", errors.New("No public key data found")
+	}
+	sum := sha256.Sum256(block.Bytes)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// tokenKeyID returns the kid header of the token without verifying it, or an empty string if it has none
+func tokenKeyID(token string) string {
+	var header struct {
+		KeyID string `json:"kid"`
+	}
+	if err := decodeTokenSegment(token, 0, &header); err != nil {
+		return ""
+	}
+	return header.KeyID
+}
+
+// ParseUnverified returns the claims of the token WITHOUT verifying its signature or validity period. The claims can
+// be forged by anyone, so they must never be used for authentication or authorization, only for diagnostics such as
+// logging who sent a token that failed validation. Use ValidateJWT to validate a token.
+func ParseUnverified(token string) (*jwt.StandardClaims, error) {
+	claims := &jwt.StandardClaims{}
+	if err := decodeTokenSegment(token, 1, claims); err != nil {
+		return nil, fmt.Errorf("Unable to parse JWT: %s", err.Error())
+	}
+	return claims, nil
+}
+
+// decodeTokenSegment decodes the JSON in the given segment (0 for the header, 1 for the claims) of the token
+func decodeTokenSegment(token string, segment int, v interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("token does not contain three segments")
+	}
+	data, err := jwt.DecodeSegment(parts[segment])
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// selectKey returns the key with the given ID
+func selectKey(keys [][]byte, keyID string) ([][]byte, error) {
+	for _, key := range keys {
+		if id, err := KeyID(key); err == nil && id == keyID {
+			return [][]byte{key}, nil
+		}
+	}
+	return nil, fmt.Errorf("Unable to verify JWT: unknown key ID %s", keyID)
+}
+
+func validateJWT(token string, publicKey []byte, leeway time.Duration, clock Clock) (*jwt.StandardClaims, error) {
+	claims := &jwt.StandardClaims{}
+	parser := &jwt.Parser{ValidMethods: ValidJWTMethods}
+	_, err := parser.ParseWithClaims(token, &leewayClaims{claims, leeway, clock}, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodECDSA:
+			return jwt.ParseECPublicKeyFromPEM(publicKey)
+		case *jwt.SigningMethodRSA:
+			return jwt.ParseRSAPublicKeyFromPEM(publicKey)
+		}
+		return nil, fmt.Errorf("Unexpected JWT signing method: %v", token.Header["alg"])
 	})
 	if err != nil {
 		return nil, fmt.Errorf("Unable to verify JWT: %s", err.Error())
 	}
 	return claims, nil
 }
+
+// NoExpiry is returned by TimeUntilExpiry for tokens that do not expire
+const NoExpiry = time.Duration(math.MaxInt64)
+
+// TimeUntilExpiry returns the remaining validity of a token with the given claims, based on the exp claim. It returns
+// zero for expired tokens and NoExpiry for tokens without an exp claim.
+func TimeUntilExpiry(claims *jwt.StandardClaims) time.Duration {
+	return TimeUntilExpiryWithClock(RealClock, claims)
+}
+
+// TimeUntilExpiryWithClock returns the remaining validity like TimeUntilExpiry, relative to the time of the given clock
+func TimeUntilExpiryWithClock(clock Clock, claims *jwt.StandardClaims) time.Duration {
+	if claims == nil || claims.ExpiresAt == 0 {
+		return NoExpiry
+	}
+	remaining := time.Unix(claims.ExpiresAt, 0).Sub(clock.Now())
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// leewayClaims validates the time-based standard claims with a leeway
+type leewayClaims struct {
+	*jwt.StandardClaims
+	leeway time.Duration
+	clock  Clock
+}
+
+// Valid implements the jwt.Claims interface
+func (c *leewayClaims) Valid() error {
+	now := c.clock.Now().Unix()
+	leeway := int64(c.leeway / time.Second)
+	if !c.VerifyExpiresAt(now-leeway, false) {
+		return jwt.NewValidationError("token is expired", jwt.ValidationErrorExpired)
+	}
+	if !c.VerifyIssuedAt(now+leeway, false) {
+		return jwt.NewValidationError("token used before issued", jwt.ValidationErrorIssuedAt)
+	}
+	if !c.VerifyNotBefore(now+leeway, false) {
+		return jwt.NewValidationError("token is not valid yet", jwt.ValidationErrorNotValidYet)
+	}
+	return nil
+}
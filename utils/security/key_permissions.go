@@ -0,0 +1,22 @@
+// +build !windows
+
+package security
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// CheckKeyPermissions returns an error if the private key file can be read or written by the group or by others,
+// like SSH does for identity files. On Windows, file modes do not reflect access control, so the check is skipped.
+func CheckKeyPermissions(file string) error {
+	info, err := os.Stat(filepath.Clean(file))
+	if err != nil {
+		return err
+	}
+	if mode := info.Mode().Perm(); mode&0077 != 0 {
+		return fmt.Errorf("Private key %s is accessible by others (mode %04o), it should only be accessible by its owner (mode 0600)", file, mode)
+	}
+	return nil
+}
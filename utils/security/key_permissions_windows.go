@@ -0,0 +1,8 @@
+// +build windows
+
+package security
+
+// CheckKeyPermissions returns nil on Windows, where file modes do not reflect access control
+func CheckKeyPermissions(file string) error {
+	return nil
+}
@@ -5,25 +5,102 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"path/filepath"
 )
 
 // LoadKeypair loads the keypair in the given location
 func LoadKeypair(location string) (*ecdsa.PrivateKey, error) {
-	priv, err := ioutil.ReadFile(filepath.Clean(location + "/server.key"))
+	return LoadKeypairWithPassphrase(location, nil)
+}
+
+// LoadKeypairWithPassphrase loads the keypair in the given location, using the passphrase to decrypt it if it is
+// encrypted
+func LoadKeypairWithPassphrase(location string, passphrase []byte) (*ecdsa.PrivateKey, error) {
+	return LoadKeypairFileWithPassphrase(location+"/server.key", passphrase)
+}
+
+// LoadKeypairFile loads the PEM-encoded keypair in the given file
+func LoadKeypairFile(file string) (*ecdsa.PrivateKey, error) {
+	return LoadKeypairFileWithPassphrase(file, nil)
+}
+
+// LoadKeypairFileWithPassphrase loads the PEM-encoded keypair in the given file, using the passphrase to decrypt it
+// if it is encrypted
+func LoadKeypairFileWithPassphrase(file string, passphrase []byte) (*ecdsa.PrivateKey, error) {
+	priv, err := ioutil.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return nil, err
+	}
+	return LoadKeypairFromPEMWithPassphrase(priv, passphrase)
+}
+
+// LoadKeypairFromReader loads the PEM-encoded keypair from the given reader
+func LoadKeypairFromReader(r io.Reader) (*ecdsa.PrivateKey, error) {
+	priv, err := ioutil.ReadAll(r)
 	if err != nil {
 		return nil, err
 	}
+	return LoadKeypairFromPEM(priv)
+}
+
+// LoadKeypairFromPEM loads the keypair from the given PEM-encoded data
+func LoadKeypairFromPEM(priv []byte) (*ecdsa.PrivateKey, error) {
+	return LoadKeypairFromPEMWithPassphrase(priv, nil)
+}
+
+// LoadKeypairFromPEMWithPassphrase loads the keypair from the given PEM-encoded data. Both SEC 1 ("EC PRIVATE KEY")
+// and PKCS#8 ("PRIVATE KEY") keys are supported. Keys that are encrypted, either as PKCS#8 ("ENCRYPTED PRIVATE KEY")
+// or with the legacy PEM encryption headers, are decrypted with the passphrase.
+func LoadKeypairFromPEMWithPassphrase(priv []byte, passphrase []byte) (*ecdsa.PrivateKey, error) {
 	privBlock, _ := pem.Decode(priv)
 	if privBlock == nil {
 		return nil, errors.New("No private key data found")
 	}
-	privKey, err := x509.ParseECPrivateKey(privBlock.Bytes)
+
+	der := privBlock.Bytes
+	if x509.IsEncryptedPEMBlock(privBlock) {
+		if len(passphrase) == 0 {
+			return nil, fmt.Errorf("Private key of type %s is encrypted, but no passphrase was given", privBlock.Type)
+		}
+		var err error
+		der, err = x509.DecryptPEMBlock(privBlock, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("Could not decrypt private key: %s", err)
+		}
+	}
+
+	switch privBlock.Type {
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(der)
+	case "PRIVATE KEY":
+		return parsePKCS8PrivateKey(der)
+	case "ENCRYPTED PRIVATE KEY":
+		if len(passphrase) == 0 {
+			return nil, fmt.Errorf("Private key of type %s is encrypted, but no passphrase was given", privBlock.Type)
+		}
+		der, err := decryptPKCS8PrivateKey(der, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("Could not decrypt private key: %s", err)
+		}
+		return parsePKCS8PrivateKey(der)
+	default:
+		return nil, fmt.Errorf("Private key of type %s is not supported", privBlock.Type)
+	}
+}
+
+func parsePKCS8PrivateKey(der []byte) (*ecdsa.PrivateKey, error) {
+	key, err := x509.ParsePKCS8PrivateKey(der)
 	if err != nil {
 		return nil, err
 	}
-	return privKey, nil
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("PKCS#8 private key of type %T is not supported", key)
+	}
+	return ecKey, nil
 }
 
 // LoadCert loads the certificate in the given location
@@ -34,3 +111,16 @@ func LoadCert(location string) (cert []byte, err error) {
 	}
 	return
 }
+
+// LoadCertPool loads the PEM-encoded CA certificates in the given file into a new x509.CertPool
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	certs, err := ioutil.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(certs) {
+		return nil, errors.New("No certificates found")
+	}
+	return pool, nil
+}
@@ -0,0 +1,117 @@
+package security
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// The Go standard library does not support encrypted PKCS#8 keys, so we decrypt the PBES2 scheme (RFC 8018) that
+// is used by OpenSSL and most other tools ourselves.
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// decryptPKCS8PrivateKey decrypts the DER-encoded EncryptedPrivateKeyInfo and returns the DER-encoded PrivateKeyInfo
+func decryptPKCS8PrivateKey(der []byte, passphrase []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, err
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("encryption algorithm %s is not supported", info.Algorithm.Algorithm)
+	}
+
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, err
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("key derivation function %s is not supported", params.KeyDerivationFunc.Algorithm)
+	}
+	var kdfParams pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdfParams); err != nil {
+		return nil, err
+	}
+
+	var prf func() hash.Hash
+	switch {
+	case len(kdfParams.PRF.Algorithm) == 0, kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA1):
+		prf = sha1.New
+	case kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA256):
+		prf = sha256.New
+	default:
+		return nil, fmt.Errorf("pseudo-random function %s is not supported", kdfParams.PRF.Algorithm)
+	}
+
+	var keyLength int
+	switch {
+	case params.EncryptionScheme.Algorithm.Equal(oidAES128CBC):
+		keyLength = 16
+	case params.EncryptionScheme.Algorithm.Equal(oidAES192CBC):
+		keyLength = 24
+	case params.EncryptionScheme.Algorithm.Equal(oidAES256CBC):
+		keyLength = 32
+	default:
+		return nil, fmt.Errorf("encryption scheme %s is not supported", params.EncryptionScheme.Algorithm)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, err
+	}
+
+	key := pbkdf2.Key(passphrase, kdfParams.Salt, kdfParams.IterationCount, keyLength, prf)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != block.BlockSize() || len(info.EncryptedData) == 0 || len(info.EncryptedData)%block.BlockSize() != 0 {
+		return nil, errors.New("invalid encrypted data")
+	}
+	data := make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(data, info.EncryptedData)
+
+	// A wrong passphrase usually results in invalid padding
+	padding := int(data[len(data)-1])
+	if padding == 0 || padding > block.BlockSize() {
+		return nil, errors.New("incorrect passphrase")
+	}
+	for _, b := range data[len(data)-padding:] {
+		if int(b) != padding {
+			return nil, errors.New("incorrect passphrase")
+		}
+	}
+	return data[:len(data)-padding], nil
+}
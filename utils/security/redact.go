@@ -0,0 +1,30 @@
+package security
+
+// RedactVisible is the number of characters that Redact keeps at the start and at the end of a secret
+var RedactVisible = 4
+
+// RedactMask replaces the redacted part of a secret
+const RedactMask = "***"
+
+// Redact masks the middle of the given token or key, so that it can be logged. Only the first and last RedactVisible
+// characters are kept, and secrets that are too short to keep them without revealing most of the secret are
+// masked entirely.
+func Redact(token string) string {
+	return RedactN(token, RedactVisible)
+}
+
+// RedactN masks the middle of the given token or key like Redact, but keeps the given number of characters at the
+// start and at the end
+func RedactN(token string, visible int) string {
+	if token == "" {
+		return ""
+	}
+	if visible < 0 {
+		visible = 0
+	}
+	// Reveal at most a quarter of the secret on each side
+	if visible > len(token)/4 {
+		visible = len(token) / 4
+	}
+	return token[:visible] + RedactMask + token[len(token)-visible:]
+}
@@ -0,0 +1,33 @@
+package security
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/assertions"
+)
+
+func TestRedact(t *testing.T) {
+	a := New(t)
+
+	a.So(Redact(""), ShouldEqual, "")
+
+	token := "ttn-account-preview.SomeVeryLongSecretAccessKeyThatShouldNotBeLogged"
+	redacted := Redact(token)
+	a.So(redacted, ShouldStartWith, token[:RedactVisible])
+	a.So(redacted, ShouldEndWith, token[len(token)-RedactVisible:])
+	a.So(redacted, ShouldNotContainSubstring, "SomeVeryLongSecret")
+
+	for _, secret := range []string{"a", "ab", "abcd", "abcdefgh", "abcdefghijkl", token} {
+		for _, visible := range []int{-1, 0, 2, 4, 8, 100} {
+			redacted := RedactN(secret, visible)
+			a.So(redacted, ShouldNotEqual, secret)
+			a.So(redacted, ShouldContainSubstring, RedactMask)
+			a.So(strings.Replace(redacted, RedactMask, "", 1), ShouldNotContainSubstring, secret)
+			a.So(len(redacted)-len(RedactMask), ShouldBeLessThanOrEqualTo, len(secret)/2)
+		}
+	}
+
+	a.So(RedactN("abcdefghijklmnop", 2), ShouldEqual, "ab***op")
+	a.So(RedactN("abcdefghijklmnop", 0), ShouldEqual, "***")
+}